@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// PipelineCheckpoint включает сохранение прогресса ExecutePipeline на диск, nil - выключено
+var PipelineCheckpoint *Checkpoint
+
+// Checkpoint хранит промежуточные результаты элементов, чтобы после падения
+// повторный запуск пропустил уже посчитанное и дал тот же результат CombineResults
+type Checkpoint struct {
+	Path     string
+	Interval time.Duration
+	// стадия, которая собирает все элементы (CombineResults), по умолчанию предпоследняя
+	CombineStage int
+
+	mu sync.Mutex
+	// загруженные из файла и еще не использованные записи, по входному значению
+	pending map[string][]checkpointItem
+	// записи текущего запуска, по trace id
+	items   map[uint64]checkpointItem
+	injects []chan injectedItem
+}
+
+// checkpointItem - Value получен на выходе стадии Stage из входного значения Input
+type checkpointItem struct {
+	Input string `json:"input"`
+	Stage int    `json:"stage"`
+	Value string `json:"value"`
+}
+
+type checkpointFile struct {
+	Items []checkpointItem `json:"items"`
+}
+
+type injectedItem struct {
+	id    uint64
+	value string
+}
+
+// NewCheckpoint читает прогресс из файла, если он уже есть
+func NewCheckpoint(path string, interval time.Duration) (*Checkpoint, error) {
+	cp := &Checkpoint{
+		Path:     path,
+		Interval: interval,
+		pending:  make(map[string][]checkpointItem),
+		items:    make(map[uint64]checkpointItem),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file := checkpointFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("cant unpack checkpoint %s: %s", path, err)
+	}
+	for _, item := range file.Items {
+		cp.pending[item.Input] = append(cp.pending[item.Input], item)
+	}
+	return cp, nil
+}
+
+// Completed возвращает число элементов, дошедших до CombineStage
+func (cp *Checkpoint) Completed() int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	result := 0
+	for _, items := range cp.pending {
+		for _, item := range items {
+			if item.Stage == cp.CombineStage-1 {
+				result++
+			}
+		}
+	}
+	for _, item := range cp.items {
+		if item.Stage == cp.CombineStage-1 {
+			result++
+		}
+	}
+	return result
+}
+
+// Save атомарно записывает прогресс: сначала во временный файл, потом rename
+func (cp *Checkpoint) Save() error {
+	cp.mu.Lock()
+	file := checkpointFile{Items: []checkpointItem{}}
+	for _, items := range cp.pending {
+		file.Items = append(file.Items, items...)
+	}
+	for _, item := range cp.items {
+		file.Items = append(file.Items, item)
+	}
+	cp.mu.Unlock()
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	tmp := cp.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.Path)
+}
+
+// start готовит чекпоинт к запуску конвейера из stages стадий и периодически сохраняет его
+// до вызова stop. stop сохраняет прогресс последний раз и возвращает первую ошибку сохранения
+func (cp *Checkpoint) start(stages int) (stop func() error) {
+	cp.mu.Lock()
+	if cp.CombineStage == 0 {
+		cp.CombineStage = stages - 2
+	}
+	cp.injects = make([]chan injectedItem, stages)
+	for i := 1; i < cp.CombineStage; i++ {
+		cp.injects[i] = make(chan injectedItem, 16)
+	}
+	cp.mu.Unlock()
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	var saveErr error
+	go func() {
+		defer close(finished)
+		if cp.Interval <= 0 {
+			<-done
+			return
+		}
+		ticker := time.NewTicker(cp.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := cp.Save(); err != nil && saveErr == nil {
+					saveErr = err
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() error {
+		close(done)
+		<-finished
+		if err := cp.Save(); err != nil && saveErr == nil {
+			saveErr = err
+		}
+		if saveErr != nil {
+			return fmt.Errorf("checkpoint save failed: %w", saveErr)
+		}
+		return nil
+	}
+}
+
+// injectChan - канал, через который восстановленные элементы попадают на вход стадии stage+1
+func (cp *Checkpoint) injectChan(stage int) chan injectedItem {
+	if cp == nil || stage >= len(cp.injects) {
+		return nil
+	}
+	return cp.injects[stage]
+}
+
+func (cp *Checkpoint) closeInjects() {
+	if cp == nil {
+		return
+	}
+	for _, ch := range cp.injects {
+		if ch != nil {
+			close(ch)
+		}
+	}
+}
+
+// resume ищет сохраненный результат для входного значения и, если он есть,
+// отправляет его сразу на нужную стадию; true значит что элемент дальше не идет
+func (cp *Checkpoint) resume(trace *Trace, value interface{}) bool {
+	if cp == nil {
+		return false
+	}
+	key := traceKey(value)
+
+	cp.mu.Lock()
+	items := cp.pending[key]
+	if len(items) == 0 {
+		cp.mu.Unlock()
+		return false
+	}
+	item := items[0]
+	if len(items) == 1 {
+		delete(cp.pending, key)
+	} else {
+		cp.pending[key] = items[1:]
+	}
+	ch := cp.injectChan(item.Stage)
+	cp.mu.Unlock()
+
+	if ch == nil {
+		// стадия из другого конвейера, считаем элемент заново
+		return false
+	}
+	ch <- injectedItem{id: trace.newItem(value), value: item.Value}
+	return true
+}
+
+// record запоминает значение элемента id на выходе стадии stage
+func (cp *Checkpoint) record(trace *Trace, id uint64, stage int, value interface{}) {
+	if cp == nil || stage == 0 || stage >= cp.CombineStage {
+		return
+	}
+	str, ok := value.(string)
+	if !ok {
+		return
+	}
+	input := trace.name(id)
+	cp.mu.Lock()
+	cp.items[id] = checkpointItem{Input: input, Stage: stage, Value: str}
+	cp.mu.Unlock()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func runCheckpointed(t *testing.T, path string, input []int) string {
+	cp, err := NewCheckpoint(path, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	PipelineCheckpoint = cp
+	defer func() {
+		PipelineCheckpoint = nil
+	}()
+
+	result := ""
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, v := range input {
+				out <- v
+			}
+		}),
+		job(SingleHash),
+		job(MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				result = val.(string)
+			}
+		}),
+	)
+	return result
+}
+
+func TestCheckpointResume(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	defer func(md5, crc32 func(string) string) {
+		DataSignerMd5, DataSignerCrc32 = md5, crc32
+	}(DataSignerMd5, DataSignerCrc32)
+	DataSignerMd5 = func(data string) string {
+		atomic.AddUint32(&md5Calls, 1)
+		return Sha256Internal("md5" + data)
+	}
+	DataSignerCrc32 = func(data string) string {
+		atomic.AddUint32(&crc32Calls, 1)
+		return Sha256Internal("crc" + data)[:8]
+	}
+
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "progress.json")
+
+	input := []int{0, 1, 1, 2, 3, 5, 8}
+	expected := runCheckpointed(t, path, input)
+	if md5Calls != 7 || crc32Calls != 7*8 {
+		t.Fatalf("unexpected hash calls for fresh run: md5 %d, crc32 %d", md5Calls, crc32Calls)
+	}
+
+	cp, err := NewCheckpoint(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.CombineStage = 3
+	if cp.Completed() != len(input) {
+		t.Fatalf("expected %d completed items, got %d", len(input), cp.Completed())
+	}
+
+	md5Calls, crc32Calls = 0, 0
+	if result := runCheckpointed(t, path, input); result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+	if md5Calls != 0 || crc32Calls != 0 {
+		t.Errorf("completed items must be skipped: md5 %d, crc32 %d", md5Calls, crc32Calls)
+	}
+
+	// элемент 13 дошел только до SingleHash, 21 не начинался
+	step1 := Sha256Internal("crc13")[:8] + "~" + Sha256Internal("crc"+Sha256Internal("md513"))[:8]
+	partial := `{"items":[{"input":"13","stage":1,"value":"` + step1 + `"}]}`
+	if err := ioutil.WriteFile(path, []byte(partial), 0644); err != nil {
+		t.Fatal(err)
+	}
+	md5Calls, crc32Calls = 0, 0
+	resumed := runCheckpointed(t, path, []int{13, 21})
+	if md5Calls != 1 || crc32Calls != 6+8 {
+		t.Errorf("unexpected hash calls for partial run: md5 %d, crc32 %d", md5Calls, crc32Calls)
+	}
+	os.Remove(path)
+	md5Calls, crc32Calls = 0, 0
+	if fresh := runCheckpointed(t, path, []int{13, 21}); fresh != resumed {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", resumed, fresh)
+	}
+}
+
+func TestCheckpointSaveError(t *testing.T) {
+	defer useFakeSigners()()
+
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// каталога для файла нет, сохранить прогресс нельзя
+	cp, err := NewCheckpoint(filepath.Join(dir, "missing", "progress.json"), time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	PipelineCheckpoint = cp
+	defer func() {
+		PipelineCheckpoint = nil
+	}()
+
+	result := ""
+	err = RunPipeline(
+		job(func(in, out chan interface{}) {
+			out <- 1
+		}),
+		job(SingleHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			result = (<-in).(string)
+		}),
+	)
+	if !errors.Is(err, os.ErrNotExist) || !strings.HasPrefix(err.Error(), "checkpoint save failed") {
+		t.Errorf("expected checkpoint save error, got %v", err)
+	}
+	if result == "" {
+		t.Errorf("pipeline must finish before reporting save error")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock - источник времени для DataSigner*, блокировок перегрева и окон CombineWindowed
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker - time.Ticker, который можно получить и от FakeClock
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// SignerClock можно подменить на FakeClock, чтобы тесты не ждали реальные секунды
+var SignerClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// FakeClock - виртуальное время. Кроме Advance, время идет само к ближайшему пробуждению,
+// когда все горутины, работающие с часами, спят в Sleep. Понять это можно двумя способами:
+//
+//   - горутины зарегистрированы через Add и Done. Тогда время идет ровно в тот момент,
+//     когда все зарегистрированные спят в Sleep. Горутина, которая ждет чего-то кроме
+//     часов (мьютекс, канал, тикер), спящей не считается, поэтому регистрировать нужно
+//     только те, что дойдут до Sleep без помощи других, иначе часы встанут. Если ни одна
+//     зарегистрированная в Sleep не попадет, время идет только через Advance;
+//   - никто не зарегистрирован. Тогда работает эвристика: спящие есть и за Settle
+//     реального времени к часам никто не обращался. Она не видит, что делают горутины
+//     на самом деле: на медленной или загруженной машине горутина может считать дольше
+//     Settle, и время уйдет вперед раньше, чем она дойдет до своего Sleep. Результаты
+//     от этого не меняются, но проверки виртуальной длительности могут врать. Годится
+//     для кода, чьи горутины не зарегистрировать, например сигнеров внутри SingleHash
+type FakeClock struct {
+	Settle time.Duration
+
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	workers int
+	// сколько горутин сейчас спит в Sleep
+	parked   int
+	activity chan struct{}
+	stop     chan struct{}
+}
+
+// fakeTimer - пробуждение Sleep или тик тикера в момент until
+type fakeTimer struct {
+	until time.Time
+	fire  func(now time.Time)
+}
+
+const defaultSettle = 5 * time.Millisecond
+
+// NewFakeClock запускает виртуальные часы с момента start, после использования нужен Stop
+func NewFakeClock(start time.Time) *FakeClock {
+	c := &FakeClock{
+		Settle:   defaultSettle,
+		now:      start,
+		activity: make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+	go c.advanceWhenIdle()
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.touch()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	wake := make(chan struct{})
+	c.mu.Lock()
+	c.parked++
+	c.schedule(&fakeTimer{until: c.now.Add(d), fire: func(time.Time) {
+		c.parked--
+		close(wake)
+	}})
+	c.touch()
+	c.advanceWhenParked()
+	c.mu.Unlock()
+	<-wake
+}
+
+// Add регистрирует n горутин, которые работают с часами, как sync.WaitGroup.Add
+func (c *FakeClock) Add(n int) {
+	c.mu.Lock()
+	c.workers += n
+	c.advanceWhenParked()
+	c.mu.Unlock()
+}
+
+// Done снимает регистрацию горутины, которая закончила работу с часами
+func (c *FakeClock) Done() {
+	c.Add(-1)
+}
+
+// Advance сдвигает время вручную и будит всех, чей срок наступил
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.advanceTo(c.now.Add(d))
+	c.mu.Unlock()
+}
+
+// Next - ближайшее пробуждение, false - никто не ждет
+func (c *FakeClock) Next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+	return c.timers[0].until, true
+}
+
+func (c *FakeClock) Stop() {
+	close(c.stop)
+}
+
+// NewTicker - тикер в виртуальном времени, тик, который некому принять, теряется
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	t := &fakeTicker{clock: c, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	ch    chan time.Time
+	timer *fakeTimer
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.ch
+}
+
+// Reset переносит следующий тик на d от текущего момента
+func (t *fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for Ticker.Reset")
+	}
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancel(t.timer)
+	var fire func(now time.Time)
+	fire = func(now time.Time) {
+		select {
+		case t.ch <- now:
+		default:
+		}
+		t.timer = &fakeTimer{until: now.Add(d), fire: fire}
+		c.schedule(t.timer)
+	}
+	t.timer = &fakeTimer{until: c.now.Add(d), fire: fire}
+	c.schedule(t.timer)
+}
+
+func (t *fakeTicker) Stop() {
+	c := t.clock
+	c.mu.Lock()
+	c.cancel(t.timer)
+	t.timer = nil
+	c.mu.Unlock()
+}
+
+// touch отмечает обращение к часам, вызывается под мьютексом
+func (c *FakeClock) touch() {
+	select {
+	case c.activity <- struct{}{}:
+	default:
+	}
+}
+
+// schedule и cancel держат timers отсортированными по until, вызываются под мьютексом
+func (c *FakeClock) schedule(t *fakeTimer) {
+	i := len(c.timers)
+	for i > 0 && c.timers[i-1].until.After(t.until) {
+		i--
+	}
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+}
+
+func (c *FakeClock) cancel(t *fakeTimer) {
+	for i := range c.timers {
+		if c.timers[i] == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+// advanceTo будит всех до момента t по порядку, вызывается под мьютексом
+func (c *FakeClock) advanceTo(t time.Time) {
+	for len(c.timers) > 0 && !c.timers[0].until.After(t) {
+		next := c.timers[0]
+		c.timers = c.timers[1:]
+		if next.until.After(c.now) {
+			c.now = next.until
+		}
+		next.fire(c.now)
+	}
+	if t.After(c.now) {
+		c.now = t
+	}
+}
+
+// advanceWhenParked переходит к ближайшему пробуждению, пока все зарегистрированные
+// горутины спят в Sleep, вызывается под мьютексом
+func (c *FakeClock) advanceWhenParked() {
+	for c.workers > 0 && c.parked >= c.workers && len(c.timers) > 0 {
+		c.advanceTo(c.timers[0].until)
+	}
+}
+
+func (c *FakeClock) advanceWhenIdle() {
+	timer := time.NewTimer(c.Settle)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-c.activity:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-timer.C:
+			c.mu.Lock()
+			if c.workers == 0 && c.parked > 0 {
+				c.advanceTo(c.timers[0].until)
+			}
+			c.mu.Unlock()
+		}
+		timer.Reset(c.Settle)
+	}
+}
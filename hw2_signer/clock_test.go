@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// сигнеры и блокировки перегрева из common.go, другие тесты подменяют их своими,
+// например TestSigner - блокировками на реальном time.Sleep
+var (
+	commonMd5, commonCrc32                   = DataSignerMd5, DataSignerCrc32
+	commonOverheatLock, commonOverheatUnlock = OverheatLock, OverheatUnlock
+)
+
+func useFakeClock(t *testing.T) (*FakeClock, func()) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	prevClock, prevMd5, prevCrc32 := SignerClock, DataSignerMd5, DataSignerCrc32
+	prevLock, prevUnlock := OverheatLock, OverheatUnlock
+	SignerClock, DataSignerMd5, DataSignerCrc32 = clock, commonMd5, commonCrc32
+	OverheatLock, OverheatUnlock = commonOverheatLock, commonOverheatUnlock
+	return clock, func() {
+		SignerClock, DataSignerMd5, DataSignerCrc32 = prevClock, prevMd5, prevCrc32
+		OverheatLock, OverheatUnlock = prevLock, prevUnlock
+		clock.Stop()
+	}
+}
+
+func TestFakeClockSleep(t *testing.T) {
+	clock, restore := useFakeClock(t)
+	defer restore()
+
+	// зарегистрированные горутины - время идет, только когда спят все
+	start := time.Now()
+	wg := &sync.WaitGroup{}
+	clock.Add(3)
+	for i := 1; i <= 3; i++ {
+		wg.Add(1)
+		go func(d time.Duration) {
+			defer wg.Done()
+			defer clock.Done()
+			clock.Sleep(d)
+			clock.Sleep(d)
+		}(time.Duration(i) * time.Hour)
+	}
+	wg.Wait()
+
+	if got := clock.Now().Sub(time.Unix(0, 0)); got != 6*time.Hour {
+		t.Errorf("virtual time not match\nGot: %s\nExpected: %s", got, 6*time.Hour)
+	}
+	if real := time.Since(start); real > time.Second {
+		t.Errorf("fake sleep took %s of real time", real)
+	}
+}
+
+func TestSignerVirtualTime(t *testing.T) {
+	clock, restore := useFakeClock(t)
+	defer restore()
+
+	testExpected := "1173136728138862632818075107442090076184424490584241521304_1696913515191343735512658979631549563179965036907783101867_27225454331033649287118297354036464389062965355426795162684_29568666068035183841425683795340791879727309630931025356555_3994492081516972096677631278379039212655368881548151736_4958044192186797981418233587017209679042592862002427381542_4958044192186797981418233587017209679042592862002427381542"
+	testResult := "NOT_SET"
+
+	start, virtualStart := time.Now(), clock.Now()
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, fibNum := range []int{0, 1, 1, 2, 3, 5, 8} {
+				out <- fibNum
+			}
+		}),
+		job(SingleHash),
+		job(MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			testResult = (<-in).(string)
+		}),
+	)
+	virtual, real := clock.Now().Sub(virtualStart), time.Since(start)
+
+	if testExpected != testResult {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", testResult, testExpected)
+	}
+	// 2 последовательных crc32 по секунде и 7 md5 по 10мс под одним мьютексом
+	if virtual < 2*time.Second || virtual > 3*time.Second {
+		t.Errorf("virtual execution time out of range\nGot: %s\nExpected: 2s..3s", virtual)
+	}
+	if real > time.Second {
+		t.Errorf("execution took %s of real time", real)
+	}
+}
+
+func TestOverheatVirtualTime(t *testing.T) {
+	clock, restore := useFakeClock(t)
+	defer restore()
+
+	virtualStart := clock.Now()
+	wg := &sync.WaitGroup{}
+	clock.Add(2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer clock.Done()
+			DataSignerMd5("0")
+		}()
+	}
+	wg.Wait()
+
+	// вызовы md5 без мьютекса перегревают сигнер на секунду
+	if virtual := clock.Now().Sub(virtualStart); virtual != time.Second+10*time.Millisecond {
+		t.Errorf("expected overheat\nGot: %s\nExpected: %s", virtual, time.Second+10*time.Millisecond)
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	clock, restore := useFakeClock(t)
+	defer restore()
+
+	ticker := clock.NewTicker(time.Minute)
+	clock.Advance(30 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatalf("tick before interval")
+	default:
+	}
+	clock.Advance(3 * time.Minute)
+	if tick := <-ticker.C(); tick != time.Unix(60, 0) {
+		t.Errorf("unexpected tick %s", tick)
+	}
+	// лишние тики теряются, как у time.Ticker
+	select {
+	case <-ticker.C():
+		t.Errorf("ticks must not pile up")
+	default:
+	}
+
+	ticker.Reset(time.Minute)
+	if next, ok := clock.Next(); !ok || next != time.Unix(270, 0) {
+		t.Errorf("reset must move next tick\nGot: %s", next)
+	}
+	ticker.Stop()
+	if _, ok := clock.Next(); ok {
+		t.Errorf("stopped ticker must not be scheduled")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CombineWindowed - CombineResults для бесконечного потока: результат по накопленным
+// значениям уходит каждые size элементов или каждые every, что наступит раньше.
+// После каждого результата окно every отсчитывается заново. Нулевой size или every
+// отключает соответствующее условие
+func CombineWindowed(size int, every time.Duration) job {
+	return func(in, out chan interface{}) {
+		var ticker Ticker
+		var tick <-chan time.Time
+		if every > 0 {
+			ticker = SignerClock.NewTicker(every)
+			defer ticker.Stop()
+			tick = ticker.C()
+		}
+
+		values := []string{}
+		flush := func() {
+			if len(values) == 0 {
+				return
+			}
+			if ticker != nil {
+				ticker.Reset(every)
+				// тик, который пришел до сброса, уже не нужен
+				select {
+				case <-tick:
+				default:
+				}
+			}
+			out <- combineValues(values)
+			values = []string{}
+		}
+
+		for {
+			select {
+			case dataRaw, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				dataStr, ok := combineItem(in, dataRaw)
+				if !ok {
+					continue
+				}
+				values = append(values, dataStr)
+				if size > 0 && len(values) >= size {
+					flush()
+				}
+			case <-tick:
+				flush()
+			}
+		}
+	}
+}
+
+// CombineExternal - CombineResults для входа, который не помещается в память. Значения
+// сортируются кусками по chunkSize и сбрасываются во временные файлы в dir, потом куски
+// сливаются потоком прямо в w. В out уходит число объединенных значений
+func CombineExternal(w io.Writer, chunkSize int, dir string) job {
+	return func(in, out chan interface{}) {
+		sorter := &externalSorter{dir: dir, chunkSize: chunkSize}
+		defer sorter.close()
+
+		for dataRaw := range in {
+			dataStr, ok := combineItem(in, dataRaw)
+			if !ok {
+				continue
+			}
+			if err := sorter.add(dataStr); err != nil {
+				panic(err)
+			}
+		}
+
+		count, err := sorter.merge(w, "_")
+		if err != nil {
+			panic(err)
+		}
+		out <- count
+	}
+}
+
+type externalSorter struct {
+	dir       string
+	chunkSize int
+	chunk     []string
+	files     []*os.File
+}
+
+func (s *externalSorter) add(value string) error {
+	s.chunk = append(s.chunk, value)
+	if s.chunkSize > 0 && len(s.chunk) >= s.chunkSize {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill пишет отсортированный кусок во временный файл, по значению в строке
+func (s *externalSorter) spill() error {
+	sort.Strings(s.chunk)
+	file, err := ioutil.TempFile(s.dir, "combine")
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, file)
+
+	w := bufio.NewWriter(file)
+	for _, value := range s.chunk {
+		if _, err := w.WriteString(strconv.Quote(value) + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	s.chunk = s.chunk[:0]
+	_, err = file.Seek(0, io.SeekStart)
+	return err
+}
+
+func (s *externalSorter) merge(w io.Writer, sep string) (int, error) {
+	sort.Strings(s.chunk)
+	cursors := &chunkHeap{}
+	if len(s.chunk) > 0 {
+		cursors.items = append(cursors.items, &chunkCursor{next: memoryChunk(s.chunk)})
+	}
+	for _, file := range s.files {
+		cursors.items = append(cursors.items, &chunkCursor{next: fileChunk(bufio.NewReader(file))})
+	}
+
+	live := cursors.items[:0]
+	for _, c := range cursors.items {
+		ok, err := c.advance()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			live = append(live, c)
+		}
+	}
+	cursors.items = live
+	heap.Init(cursors)
+
+	bw := bufio.NewWriter(w)
+	count := 0
+	for cursors.Len() > 0 {
+		c := cursors.items[0]
+		if count > 0 {
+			bw.WriteString(sep)
+		}
+		bw.WriteString(c.value)
+		count++
+
+		ok, err := c.advance()
+		if err != nil {
+			return count, err
+		}
+		if ok {
+			heap.Fix(cursors, 0)
+		} else {
+			heap.Pop(cursors)
+		}
+	}
+	return count, bw.Flush()
+}
+
+func (s *externalSorter) close() {
+	for _, file := range s.files {
+		file.Close()
+		os.Remove(file.Name())
+	}
+}
+
+func memoryChunk(values []string) func() (string, bool, error) {
+	return func() (string, bool, error) {
+		if len(values) == 0 {
+			return "", false, nil
+		}
+		value := values[0]
+		values = values[1:]
+		return value, true, nil
+	}
+}
+
+func fileChunk(r *bufio.Reader) func() (string, bool, error) {
+	return func() (string, bool, error) {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return "", false, nil
+		}
+		if err != nil && err != io.EOF {
+			return "", false, err
+		}
+		value, err := strconv.Unquote(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			return "", false, fmt.Errorf("broken combine chunk: %s", err)
+		}
+		return value, true, nil
+	}
+}
+
+type chunkCursor struct {
+	value string
+	next  func() (string, bool, error)
+}
+
+func (c *chunkCursor) advance() (bool, error) {
+	value, ok, err := c.next()
+	c.value = value
+	return ok, err
+}
+
+type chunkHeap struct {
+	items []*chunkCursor
+}
+
+func (h *chunkHeap) Len() int           { return len(h.items) }
+func (h *chunkHeap) Less(i, j int) bool { return h.items[i].value < h.items[j].value }
+func (h *chunkHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *chunkHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(*chunkCursor))
+}
+
+func (h *chunkHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
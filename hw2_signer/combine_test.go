@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func collectCombined(input []interface{}, combine job) []interface{} {
+	results := []interface{}{}
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, v := range input {
+				out <- v
+			}
+		}),
+		combine,
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				results = append(results, val)
+			}
+		}),
+	)
+	return results
+}
+
+func TestCombineWindowedBySize(t *testing.T) {
+	results := collectCombined([]interface{}{"e", "d", "c", "b", "a"}, CombineWindowed(2, 0))
+	expected := []interface{}{"d_e", "b_c", "a"}
+	if len(results) != len(expected) {
+		t.Fatalf("results not match\nGot: %v\nExpected: %v", results, expected)
+	}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Errorf("results not match\nGot: %v\nExpected: %v", results, expected)
+		}
+	}
+}
+
+func TestCombineWindowedByTime(t *testing.T) {
+	clock, restore := useFakeClock(t)
+	defer restore()
+	// CombineWindowed ждет тикер, а не Sleep, поэтому время идет только через Advance
+	clock.Add(1)
+
+	in, out := make(chan interface{}), make(chan interface{})
+	go func() {
+		CombineWindowed(2, time.Minute)(in, out)
+		close(out)
+	}()
+
+	in <- "b"
+	clock.Advance(time.Minute)
+	if v := <-out; v != "b" {
+		t.Errorf("expected window by time\nGot: %v", v)
+	}
+
+	// окно по размеру отсчитывает время заново
+	clock.Advance(30 * time.Second)
+	in <- "d"
+	in <- "c"
+	if v := <-out; v != "c_d" {
+		t.Errorf("expected window by size\nGot: %v", v)
+	}
+	if next, _ := clock.Next(); next != time.Unix(150, 0) {
+		t.Errorf("window must restart after flush\nGot: %s\nExpected: %s", next, time.Unix(150, 0))
+	}
+
+	in <- "e"
+	clock.Advance(time.Minute)
+	if v := <-out; v != "e" {
+		t.Errorf("expected window by time\nGot: %v", v)
+	}
+	close(in)
+	if v, ok := <-out; ok {
+		t.Errorf("unexpected result %v", v)
+	}
+}
+
+func TestCombineExternal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := []interface{}{}
+	for i := 0; i < 50; i++ {
+		input = append(input, strconv.Itoa((i*37)%50))
+	}
+	input = append(input, "with\nnewline", "")
+
+	expected := collectCombined(input, CombineResults)[0]
+
+	buf := &bytes.Buffer{}
+	results := collectCombined(input, CombineExternal(buf, 7, dir))
+	if buf.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", buf.String(), expected)
+	}
+	if len(results) != 1 || results[0] != len(input) {
+		t.Errorf("expected count %d, got %v", len(input), results)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("temporary chunks not removed: %d", len(files))
+	}
+}
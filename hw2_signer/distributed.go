@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// стадии, которые воркер умеет считать для координатора
+var (
+	workerStagesMutex = &sync.RWMutex{}
+	workerStages      = map[string]func(data string) string{
+		"SingleHash": func(data string) string {
+			return MustParseSignFormula(SingleHashExpr).Sign(data)
+		},
+		"MultiHash": func(data string) string {
+			return multiHashSign(MustParseSignFormula(MultiHashExpr), 0, data)
+		},
+	}
+)
+
+func RegisterWorkerStage(name string, stage func(data string) string) {
+	workerStagesMutex.Lock()
+	workerStages[name] = stage
+	workerStagesMutex.Unlock()
+}
+
+func lookupWorkerStage(name string) (func(data string) string, bool) {
+	workerStagesMutex.RLock()
+	defer workerStagesMutex.RUnlock()
+	stage, ok := workerStages[name]
+	return stage, ok
+}
+
+// listen понимает адреса вида host:port и unix:/path/to/socket
+func listen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		return net.Listen("unix", strings.TrimPrefix(addr, "unix:"))
+	}
+	return net.Listen("tcp", addr)
+}
+
+func listenerAddr(l net.Listener) string {
+	if l.Addr().Network() == "unix" {
+		return "unix:" + l.Addr().String()
+	}
+	return l.Addr().String()
+}
+
+type workerRegistration struct {
+	ID   string `json:"id,omitempty"`
+	Addr string `json:"addr"`
+}
+
+// Worker считает стадии конвейера по запросам координатора
+type Worker struct {
+	ID          string
+	coordinator string
+	listener    net.Listener
+	server      *http.Server
+}
+
+// StartWorker слушает addr и регистрируется у координатора
+func StartWorker(addr, coordinator string) (*Worker, error) {
+	l, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Worker{coordinator: coordinator, listener: l}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sign", w.handleSign)
+	w.server = &http.Server{Handler: mux}
+	go w.server.Serve(l)
+
+	reg := workerRegistration{}
+	if err := coordinatorCall(coordinator, "/register", workerRegistration{Addr: listenerAddr(l)}, &reg); err != nil {
+		w.server.Close()
+		return nil, fmt.Errorf("cant register worker: %s", err)
+	}
+	w.ID = reg.ID
+	return w, nil
+}
+
+func (w *Worker) Addr() string {
+	return listenerAddr(w.listener)
+}
+
+// handleSign сразу отвечает заголовками - это подтверждение приема, результат идет телом позже.
+// Паника стадии приходит в трейлере Sign-Error, чтобы координатор не принял ее за упавший воркер
+func (w *Worker) handleSign(rw http.ResponseWriter, r *http.Request) {
+	stage, ok := lookupWorkerStage(r.URL.Query().Get("stage"))
+	if !ok {
+		http.Error(rw, "unknown stage", http.StatusNotFound)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Trailer", "Sign-Error")
+	rw.WriteHeader(http.StatusOK)
+	if flusher, ok := rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	result, err := runWorkerStage(stage, string(data))
+	if err != nil {
+		rw.Header().Set("Sign-Error", err.Error())
+		return
+	}
+	rw.Write([]byte(result))
+}
+
+func runWorkerStage(stage func(data string) string, data string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("stage panic: %v", r)
+		}
+	}()
+	return stage(data), nil
+}
+
+// Stop снимает воркер с регистрации и останавливает его
+func (w *Worker) Stop() error {
+	coordinatorCall(w.coordinator, "/unregister", workerRegistration{ID: w.ID}, nil)
+	return w.Close()
+}
+
+// Close останавливает воркер без предупреждения, как если бы процесс упал
+func (w *Worker) Close() error {
+	return w.server.Close()
+}
+
+func coordinatorCall(coordinator, path string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: dialContext(coordinator), DisableKeepAlives: true},
+		Timeout:   5 * time.Second,
+	}
+	r, err := client.Post(httpURL(coordinator)+path, "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("coordinator status %d", r.StatusCode)
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+func httpURL(addr string) string {
+	if strings.HasPrefix(addr, "unix:") {
+		return "http://unix"
+	}
+	return "http://" + addr
+}
+
+// dialContext подключается к unix-сокету из адреса, а не к хосту из урла
+func dialContext(addr string) func(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	if !strings.HasPrefix(addr, "unix:") {
+		return dialer.DialContext
+	}
+	path := strings.TrimPrefix(addr, "unix:")
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", path)
+	}
+}
+
+type workerInfo struct {
+	id     string
+	addr   string
+	client *http.Client
+}
+
+// Coordinator раздает элементы стадии зарегистрированным воркерам. Воркер, который не
+// подтвердил прием за AckTimeout, не прислал результат за ItemTimeout или оборвал ответ,
+// считается упавшим, а элемент уходит другому. Ошибку самого воркера, например неизвестную
+// стадию или панику стадии, получает стадия конвейера, воркер остается в работе
+type Coordinator struct {
+	AckTimeout  time.Duration
+	ItemTimeout time.Duration
+	// сколько воркеров пробовать для одного элемента, прежде чем отдать ошибку стадии
+	MaxAttempts int
+	// сколько ждать появления воркера, если живых не осталось
+	WaitWorkers time.Duration
+
+	listener net.Listener
+	server   *http.Server
+
+	mu      sync.Mutex
+	workers []*workerInfo
+	nextID  int
+	next    int
+	changed chan struct{}
+}
+
+func StartCoordinator(addr string) (*Coordinator, error) {
+	l, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Coordinator{
+		AckTimeout:  time.Second,
+		ItemTimeout: 30 * time.Second,
+		MaxAttempts: 3,
+		WaitWorkers: 10 * time.Second,
+		listener:    l,
+		changed:     make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", c.handleRegister)
+	mux.HandleFunc("/unregister", c.handleUnregister)
+	c.server = &http.Server{Handler: mux}
+	go c.server.Serve(l)
+	return c, nil
+}
+
+func (c *Coordinator) Addr() string {
+	return listenerAddr(c.listener)
+}
+
+func (c *Coordinator) Close() error {
+	c.mu.Lock()
+	for _, w := range c.workers {
+		w.client.CloseIdleConnections()
+	}
+	c.mu.Unlock()
+	return c.server.Close()
+}
+
+// Workers возвращает адреса живых воркеров
+func (c *Coordinator) Workers() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := []string{}
+	for _, w := range c.workers {
+		result = append(result, w.addr)
+	}
+	return result
+}
+
+func (c *Coordinator) handleRegister(rw http.ResponseWriter, r *http.Request) {
+	reg := workerRegistration{}
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil || reg.Addr == "" {
+		http.Error(rw, "bad registration", http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	c.nextID++
+	reg.ID = fmt.Sprintf("worker-%d", c.nextID)
+	c.workers = append(c.workers, &workerInfo{
+		id:   reg.ID,
+		addr: reg.Addr,
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: dialContext(reg.Addr),
+		}},
+	})
+	c.notify()
+	c.mu.Unlock()
+
+	json.NewEncoder(rw).Encode(reg)
+}
+
+func (c *Coordinator) handleUnregister(rw http.ResponseWriter, r *http.Request) {
+	reg := workerRegistration{}
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		http.Error(rw, "bad registration", http.StatusBadRequest)
+		return
+	}
+	c.remove(reg.ID)
+}
+
+// notify будит тех, кто ждет воркеров, вызывается под мьютексом
+func (c *Coordinator) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *Coordinator) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, w := range c.workers {
+		if w.id == id {
+			w.client.CloseIdleConnections()
+			c.workers = append(c.workers[:i], c.workers[i+1:]...)
+			c.notify()
+			return
+		}
+	}
+}
+
+// pick выбирает воркер по кругу, если воркеров нет - ждет регистрации
+func (c *Coordinator) pick() (*workerInfo, error) {
+	deadline := time.After(c.WaitWorkers)
+	for {
+		c.mu.Lock()
+		if len(c.workers) > 0 {
+			c.next = (c.next + 1) % len(c.workers)
+			w := c.workers[c.next]
+			c.mu.Unlock()
+			return w, nil
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return nil, fmt.Errorf("no workers registered in %s", c.WaitWorkers)
+		}
+	}
+}
+
+func (c *Coordinator) dispatch(stage, data string) (string, error) {
+	attempts := c.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		w, pickErr := c.pick()
+		if pickErr != nil {
+			return "", pickErr
+		}
+		var result string
+		result, err = w.sign(stage, data, c.AckTimeout, c.ItemTimeout)
+		if err == nil {
+			return result, nil
+		}
+		if _, ok := err.(*WorkerError); ok {
+			return "", err
+		}
+		c.remove(w.id)
+	}
+	return "", fmt.Errorf("%d workers failed, last: %w", attempts, err)
+}
+
+// WorkerError - воркер принял элемент, но ответил ошибкой
+type WorkerError struct {
+	Worker string
+	Status int
+	Body   string
+}
+
+func (e *WorkerError) Error() string {
+	return fmt.Sprintf("worker %s status %d: %s", e.Worker, e.Status, e.Body)
+}
+
+// sign отправляет элемент воркеру: заголовки ответа ждет ackTimeout, весь ответ - itemTimeout
+func (w *workerInfo) sign(stage, data string, ackTimeout, itemTimeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), itemTimeout)
+	defer cancel()
+	ack := time.AfterFunc(ackTimeout, cancel)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", httpURL(w.addr)+"/sign?stage="+url.QueryEscape(stage), strings.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := w.client.Do(req)
+	if !ack.Stop() && err == nil {
+		resp.Body.Close()
+		err = fmt.Errorf("worker %s ack timeout %s", w.id, ackTimeout)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &WorkerError{Worker: w.id, Status: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	if msg := resp.Trailer.Get("Sign-Error"); msg != "" {
+		return "", &WorkerError{Worker: w.id, Status: http.StatusInternalServerError, Body: msg}
+	}
+	return string(body), nil
+}
+
+// Stage - стадия конвейера, которая считается на воркерах вместо текущего процесса.
+// Ошибка элемента обрабатывается как паника на нем: при AbortOnPanic конвейер
+// останавливается на первой, при DeadLetterOnPanic она уходит в PipelineDeadLetter
+func (c *Coordinator) Stage(name string) job {
+	return func(in, out chan interface{}) {
+		wg := &sync.WaitGroup{}
+
+		for dataRaw := range in {
+			dataStr, ok := itemString(in, dataRaw)
+			if !ok {
+				continue
+			}
+			wg.Add(1)
+
+			go func(item interface{}, data string, id uint64) {
+				defer wg.Done()
+				end := PipelineTrace.span(id, name+" remote", nil)
+				result, err := c.dispatch(name, data)
+				end()
+				if err != nil {
+					failItem(in, item, err)
+					return
+				}
+				PipelineTrace.bind(result, id)
+				out <- result
+			}(dataRaw, dataStr, PipelineTrace.take(dataStr))
+		}
+
+		wg.Wait()
+	}
+}
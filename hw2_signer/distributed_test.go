@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startCluster(t *testing.T, coordinatorAddr string, workerAddrs ...string) (*Coordinator, []*Worker) {
+	c, err := StartCoordinator(coordinatorAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workers := []*Worker{}
+	for _, addr := range workerAddrs {
+		w, err := StartWorker(addr, c.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		workers = append(workers, w)
+	}
+	return c, workers
+}
+
+func runRemote(input []int, stages ...job) string {
+	result := ""
+	jobs := []job{job(func(in, out chan interface{}) {
+		for _, v := range input {
+			out <- v
+		}
+	})}
+	jobs = append(jobs, stages...)
+	jobs = append(jobs, job(CombineResults), job(func(in, out chan interface{}) {
+		result = (<-in).(string)
+	}))
+	ExecutePipeline(jobs...)
+	return result
+}
+
+func TestDistributedStages(t *testing.T) {
+	defer useFakeSigners()()
+
+	c, workers := startCluster(t, "127.0.0.1:0", "127.0.0.1:0", "127.0.0.1:0", "127.0.0.1:0")
+	defer c.Close()
+	for _, w := range workers {
+		defer w.Close()
+	}
+
+	input := []int{0, 1, 1, 2, 3, 5, 8}
+	expected := runRemote(input, job(SingleHash), job(MultiHash))
+	if result := runRemote(input, c.Stage("SingleHash"), c.Stage("MultiHash")); result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	workers[0].Stop()
+	if len(c.Workers()) != 2 {
+		t.Errorf("stopped worker must unregister: %v", c.Workers())
+	}
+}
+
+func TestDistributedUnixSockets(t *testing.T) {
+	defer useFakeSigners()()
+
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, workers := startCluster(t, "unix:"+filepath.Join(dir, "coordinator.sock"),
+		"unix:"+filepath.Join(dir, "worker1.sock"), "unix:"+filepath.Join(dir, "worker2.sock"))
+	defer c.Close()
+	for _, w := range workers {
+		defer w.Close()
+	}
+
+	input := []int{3, 5, 8}
+	expected := runRemote(input, job(MultiHash))
+	if result := runRemote(input, c.Stage("MultiHash")); result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+}
+
+func TestDistributedWorkerDies(t *testing.T) {
+	started := make(chan struct{}, 100)
+	RegisterWorkerStage("slow", func(data string) string {
+		started <- struct{}{}
+		time.Sleep(50 * time.Millisecond)
+		return "slow" + data
+	})
+
+	c, workers := startCluster(t, "127.0.0.1:0", "127.0.0.1:0", "127.0.0.1:0")
+	defer c.Close()
+	defer workers[1].Close()
+
+	// воркер, который принимает соединение, но не подтверждает прием
+	hang := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer hanging.Close()
+	defer close(hang)
+	if err := coordinatorCall(c.Addr(), "/register", workerRegistration{Addr: strings.TrimPrefix(hanging.URL, "http://")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// таймаут читается при каждой отправке, в том числе для уже зарегистрированных
+	c.AckTimeout = 20 * time.Millisecond
+
+	once := &sync.Once{}
+	go func() {
+		<-started
+		once.Do(func() { workers[0].Close() })
+	}()
+
+	input := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	result := runRemote(input, c.Stage("slow"))
+	expected := "slow0_slow1_slow2_slow3_slow4_slow5_slow6_slow7"
+	if result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+	if alive := c.Workers(); len(alive) != 1 || alive[0] != workers[1].Addr() {
+		t.Errorf("dead workers must be removed: %v", alive)
+	}
+}
+
+func TestDistributedWorkerHangsAfterAck(t *testing.T) {
+	defer useFakeSigners()()
+
+	c, workers := startCluster(t, "127.0.0.1:0", "127.0.0.1:0")
+	defer c.Close()
+	defer workers[0].Close()
+
+	// воркер, который подтверждает прием, но не присылает результат
+	hang := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-hang
+	}))
+	defer hanging.Close()
+	defer close(hang)
+	if err := coordinatorCall(c.Addr(), "/register", workerRegistration{Addr: strings.TrimPrefix(hanging.URL, "http://")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	c.ItemTimeout = 50 * time.Millisecond
+
+	input := []int{1, 2, 3, 4}
+	expected := runRemote(input, job(SingleHash))
+	if result := runRemote(input, c.Stage("SingleHash")); result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+	if alive := c.Workers(); len(alive) != 1 || alive[0] != workers[0].Addr() {
+		t.Errorf("hanging worker must be removed: %v", alive)
+	}
+}
+
+func TestDistributedWorkerError(t *testing.T) {
+	defer useFakeSigners()()
+
+	c, workers := startCluster(t, "127.0.0.1:0", "127.0.0.1:0", "127.0.0.1:0")
+	defer c.Close()
+	for _, w := range workers {
+		defer w.Close()
+	}
+
+	err := RunPipeline(
+		job(func(in, out chan interface{}) {
+			out <- 1
+		}),
+		c.Stage("NoSuchStage"),
+		job(CombineResults),
+	)
+	workerErr := &WorkerError{}
+	if !errors.As(err, &workerErr) || workerErr.Status != http.StatusNotFound || workerErr.Body != "unknown stage" {
+		t.Errorf("expected WorkerError for unknown stage, got %v", err)
+	}
+	if alive := c.Workers(); len(alive) != 2 {
+		t.Errorf("worker error must not remove workers: %v", alive)
+	}
+}
+
+func TestDistributedStagePanic(t *testing.T) {
+	RegisterWorkerStage("explode", func(data string) string {
+		if data == "2" {
+			panic("boom")
+		}
+		return "ok" + data
+	})
+
+	c, workers := startCluster(t, "127.0.0.1:0", "127.0.0.1:0", "127.0.0.1:0")
+	defer c.Close()
+	for _, w := range workers {
+		defer w.Close()
+	}
+
+	err := RunPipeline(
+		job(func(in, out chan interface{}) {
+			for _, v := range []int{1, 2, 3} {
+				out <- v
+			}
+		}),
+		c.Stage("explode"),
+		job(CombineResults),
+	)
+	stageErr := &StageError{}
+	if !errors.As(err, &stageErr) || stageErr.Item != 2 {
+		t.Fatalf("expected StageError on item 2, got %v", err)
+	}
+	workerErr := &WorkerError{}
+	if !errors.As(err, &workerErr) || workerErr.Status != http.StatusInternalServerError || workerErr.Body != "stage panic: boom" {
+		t.Errorf("expected WorkerError with stage panic, got %v", err)
+	}
+	if alive := c.Workers(); len(alive) != 2 {
+		t.Errorf("stage panic must not remove workers: %v", alive)
+	}
+}
+
+func TestDistributedMaxAttempts(t *testing.T) {
+	c, _ := startCluster(t, "127.0.0.1:0")
+	defer c.Close()
+	c.MaxAttempts = 2
+
+	// воркеры, которые обрывают соединение
+	for i := 0; i < 3; i++ {
+		dropping := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+		defer dropping.Close()
+		if err := coordinatorCall(c.Addr(), "/register", workerRegistration{Addr: strings.TrimPrefix(dropping.URL, "http://")}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := c.dispatch("SingleHash", "1")
+	if err == nil || !strings.HasPrefix(err.Error(), "2 workers failed") {
+		t.Errorf("expected error after 2 attempts, got %v", err)
+	}
+	if alive := c.Workers(); len(alive) != 1 {
+		t.Errorf("only tried workers must be removed: %v", alive)
+	}
+}
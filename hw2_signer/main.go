@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+func main() {
+	listenAddr := flag.String("listen", "127.0.0.1:0", "адрес воркера, host:port или unix:/path")
+	coordinator := flag.String("coordinator", "", "адрес координатора, к которому подключается воркер")
+	flag.Parse()
+
+	if *coordinator == "" {
+		fmt.Println("usage: go test -v -race")
+		fmt.Println("worker: hw2_signer -coordinator 127.0.0.1:9000 [-listen 127.0.0.1:0]")
+		return
+	}
+
+	worker, err := StartWorker(*listenAddr, *coordinator)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("worker", worker.ID, "listening at", worker.Addr())
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+	worker.Stop()
+}
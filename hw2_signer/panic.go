@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// PanicPolicy - что делать конвейеру, если стадия запаниковала
+type PanicPolicy int
+
+const (
+	// AbortOnPanic останавливает конвейер, RunPipeline возвращает *StageError
+	AbortOnPanic PanicPolicy = iota
+	// DeadLetterOnPanic отправляет *StageError в PipelineDeadLetter и продолжает работу
+	DeadLetterOnPanic
+)
+
+var (
+	PipelinePanicPolicy = AbortOnPanic
+	// если канал не задан, первую ошибку DeadLetterOnPanic RunPipeline вернет,
+	// когда конвейер доработает
+	PipelineDeadLetter chan *StageError
+)
+
+// StageError - паника в стадии Stage на элементе Item
+type StageError struct {
+	Stage int
+	Item  interface{}
+	Panic interface{}
+	Stack []byte
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("stage %d panic on item %v: %v", e.Stage, e.Item, e.Panic)
+}
+
+func (e *StageError) Unwrap() error {
+	err, _ := e.Panic.(error)
+	return err
+}
+
+type pipelineRun struct {
+	policy     PanicPolicy
+	deadLetter chan *StageError
+
+	abort     chan struct{}
+	abortOnce sync.Once
+	mu        sync.Mutex
+	err       *StageError
+	// первая ошибка DeadLetterOnPanic, которую некуда было отправить
+	dropped *StageError
+	// стадии по входному каналу, чтобы itemString знал, в какой стадии он вызван
+	stages map[chan interface{}]*stageRun
+}
+
+var (
+	runsMutex = &sync.Mutex{}
+	// идущие конвейеры, свои стадии каждый хранит сам
+	activeRuns = map[*pipelineRun]bool{}
+)
+
+// newPipelineRun заводит запуск конвейера, после конвейера нужен finish
+func newPipelineRun() *pipelineRun {
+	run := &pipelineRun{
+		policy:     PipelinePanicPolicy,
+		deadLetter: PipelineDeadLetter,
+		abort:      make(chan struct{}),
+		stages:     map[chan interface{}]*stageRun{},
+	}
+	runsMutex.Lock()
+	activeRuns[run] = true
+	runsMutex.Unlock()
+	return run
+}
+
+func (run *pipelineRun) finish() {
+	runsMutex.Lock()
+	delete(activeRuns, run)
+	runsMutex.Unlock()
+}
+
+func (run *pipelineRun) fail(err *StageError) {
+	run.abortOnce.Do(func() {
+		run.mu.Lock()
+		run.err = err
+		run.mu.Unlock()
+		close(run.abort)
+	})
+}
+
+func (run *pipelineRun) aborted() bool {
+	select {
+	case <-run.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+func (run *pipelineRun) result() error {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	switch {
+	case run.err != nil:
+		return run.err
+	case run.dropped != nil:
+		return run.dropped
+	}
+	return nil
+}
+
+func (run *pipelineRun) sendDeadLetter(err *StageError) {
+	if run.deadLetter == nil {
+		run.mu.Lock()
+		if run.dropped == nil {
+			run.dropped = err
+		}
+		run.mu.Unlock()
+		return
+	}
+	run.deadLetter <- err
+}
+
+// stageRun - запуск одной стадии, помнит последний полученный ей элемент
+type stageRun struct {
+	pipeline *pipelineRun
+	index    int
+	in       chan interface{}
+
+	mu        sync.Mutex
+	lastItem  interface{}
+	delivered int
+}
+
+func (run *pipelineRun) stage(index int) *stageRun {
+	return &stageRun{pipeline: run, index: index}
+}
+
+// register запоминает входной канал стадии, у первой стадии входа нет
+func (s *stageRun) register(in chan interface{}) {
+	s.in = in
+	s.pipeline.mu.Lock()
+	s.pipeline.stages[in] = s
+	s.pipeline.mu.Unlock()
+}
+
+// lookupStage ищет стадию с входом in среди идущих конвейеров
+func lookupStage(in chan interface{}) *stageRun {
+	runsMutex.Lock()
+	defer runsMutex.Unlock()
+	for run := range activeRuns {
+		run.mu.Lock()
+		s := run.stages[in]
+		run.mu.Unlock()
+		if s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+func (s *stageRun) deliver(item interface{}) {
+	s.mu.Lock()
+	s.lastItem = item
+	s.delivered++
+	s.mu.Unlock()
+}
+
+func (s *stageRun) progress() (interface{}, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastItem, s.delivered
+}
+
+func (s *stageRun) newError(item, panicValue interface{}) *StageError {
+	return &StageError{Stage: s.index, Item: item, Panic: panicValue, Stack: debug.Stack()}
+}
+
+// run выполняет стадию и перехватывает ее панику. При DeadLetterOnPanic стадия
+// перезапускается на оставшемся входе, если после прошлого запуска она получила новые
+// элементы. Паники в горутинах, которые стадия запустила сама, сюда не попадают,
+// такие горутины перехватывают их через recoverItem
+func (s *stageRun) run(j job, in, out chan interface{}) {
+	for {
+		_, before := s.progress()
+		err := s.runOnce(j, in, out)
+		if err == nil {
+			break
+		}
+		_, after := s.progress()
+		if s.pipeline.policy == AbortOnPanic || s.in == nil || after == before {
+			s.pipeline.fail(err)
+			break
+		}
+		s.pipeline.sendDeadLetter(err)
+	}
+
+	if s.in != nil {
+		s.pipeline.mu.Lock()
+		delete(s.pipeline.stages, s.in)
+		s.pipeline.mu.Unlock()
+	}
+}
+
+func (s *stageRun) runOnce(j job, in, out chan interface{}) (err *StageError) {
+	defer func() {
+		if r := recover(); r != nil {
+			if stageErr, ok := r.(*StageError); ok {
+				err = stageErr
+				return
+			}
+			item, _ := s.progress()
+			err = s.newError(item, r)
+		}
+	}()
+	j(in, out)
+	return nil
+}
+
+// itemString приводит элемент стадии к строке. При DeadLetterOnPanic элемент, который
+// не приводится, уходит в PipelineDeadLetter, а стадия продолжает работу без него.
+// Иначе стадия падает с *StageError, в котором точно известен элемент
+func itemString(in chan interface{}, dataRaw interface{}) (result string, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			s := lookupStage(in)
+			if s == nil {
+				panic(r)
+			}
+			err := s.newError(dataRaw, r)
+			if s.pipeline.policy != DeadLetterOnPanic {
+				panic(err)
+			}
+			s.pipeline.sendDeadLetter(err)
+			result, ok = "", false
+		}
+	}()
+	return ToStringCustom(dataRaw), true
+}
+
+// signPanic - паника в горутине подписи, которую надо поднять в горутине элемента
+// вместе со стеком, где она случилась
+type signPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// goSign считает подпись в отдельной горутине. Паника в ней не роняет процесс, а
+// поднимается как *signPanic в горутине, которая заберет результат через awaitSign
+func goSign(sign func() string) chan interface{} {
+	result := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				if p, ok := r.(*signPanic); ok {
+					result <- p
+					return
+				}
+				result <- &signPanic{value: r, stack: debug.Stack()}
+			}
+		}()
+		result <- sign()
+	}()
+	return result
+}
+
+// awaitSign дожидается всех подписей goSign и возвращает их по порядку
+func awaitSign(chans []chan interface{}) []string {
+	parts := make([]string, len(chans))
+	var failed *signPanic
+	for i, ch := range chans {
+		switch val := (<-ch).(type) {
+		case string:
+			parts[i] = val
+		case *signPanic:
+			failed = val
+		}
+	}
+	if failed != nil {
+		panic(failed)
+	}
+	return parts
+}
+
+// recoverItem вызывается через defer в горутине, которую стадия in запустила для элемента
+// item. Паника становится *StageError: при DeadLetterOnPanic уходит в PipelineDeadLetter,
+// иначе останавливает конвейер. Вне конвейера паника поднимается дальше
+func recoverItem(in chan interface{}, item interface{}) {
+	if r := recover(); r != nil {
+		failItem(in, item, r)
+	}
+}
+
+// failItem - то же, что паника на элементе item, для стадий, которые получают ошибку
+// без паники, например от воркера
+func failItem(in chan interface{}, item, r interface{}) {
+	s := lookupStage(in)
+	if s == nil {
+		panic(r)
+	}
+	err := s.newError(item, r)
+	if p, ok := r.(*signPanic); ok {
+		err.Panic, err.Stack = p.value, p.stack
+	}
+	if s.pipeline.policy == DeadLetterOnPanic {
+		s.pipeline.sendDeadLetter(err)
+		return
+	}
+	s.pipeline.fail(err)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func panicJobs(result *string) []job {
+	return []job{
+		job(func(in, out chan interface{}) {
+			for _, v := range []interface{}{1, 2.5, 3} {
+				out <- v
+			}
+		}),
+		job(SingleHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				*result = val.(string)
+			}
+		}),
+	}
+}
+
+func useFakeSigners() func() {
+	md5, crc32 := DataSignerMd5, DataSignerCrc32
+	DataSignerMd5 = func(data string) string { return "m" + data }
+	DataSignerCrc32 = func(data string) string { return "c" + data }
+	return func() {
+		DataSignerMd5, DataSignerCrc32 = md5, crc32
+	}
+}
+
+func TestPanicAbort(t *testing.T) {
+	defer useFakeSigners()()
+
+	result := ""
+	err := RunPipeline(panicJobs(&result)...)
+
+	stageErr := &StageError{}
+	if !errors.As(err, &stageErr) {
+		t.Fatalf("expected *StageError, got %v", err)
+	}
+	if stageErr.Stage != 1 || stageErr.Item != 2.5 {
+		t.Errorf("unexpected stage error: stage %d, item %v", stageErr.Stage, stageErr.Item)
+	}
+	if !strings.Contains(string(stageErr.Stack), "ToStringCustom") {
+		t.Errorf("stack must point to the panic:\n%s", stageErr.Stack)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("ExecutePipeline must panic in caller goroutine")
+		}
+	}()
+	ExecutePipeline(panicJobs(&result)...)
+}
+
+func TestPanicDeadLetter(t *testing.T) {
+	defer useFakeSigners()()
+	defer func(policy PanicPolicy, ch chan *StageError) {
+		PipelinePanicPolicy, PipelineDeadLetter = policy, ch
+	}(PipelinePanicPolicy, PipelineDeadLetter)
+	PipelinePanicPolicy = DeadLetterOnPanic
+	PipelineDeadLetter = make(chan *StageError, 10)
+
+	result := ""
+	if err := RunPipeline(panicJobs(&result)...); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result != "c1~cm1_c3~cm3" {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, "c1~cm1_c3~cm3")
+	}
+
+	close(PipelineDeadLetter)
+	letters := []*StageError{}
+	for err := range PipelineDeadLetter {
+		letters = append(letters, err)
+	}
+	if len(letters) != 1 || letters[0].Stage != 1 || letters[0].Item != 2.5 {
+		t.Errorf("unexpected dead letters: %v", letters)
+	}
+
+	// стадия без itemString перезапускается на оставшихся элементах
+	PipelineDeadLetter = make(chan *StageError, 10)
+	received := []int{}
+	err := RunPipeline(
+		job(func(in, out chan interface{}) {
+			for _, v := range []int{1, 2, 3} {
+				out <- v
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			for v := range in {
+				if v.(int) == 2 {
+					panic("bad item")
+				}
+				out <- v
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			for v := range in {
+				received = append(received, v.(int))
+			}
+		}),
+	)
+	if err != nil || len(received) != 2 || len(PipelineDeadLetter) != 1 {
+		t.Errorf("unexpected restart result: %v, %v, %d dead letters", err, received, len(PipelineDeadLetter))
+	}
+}
+
+// panicSigner падает на подписи "2", в том числе внутри MultiHash ("02".."52")
+var panicSigner = SignerFunc(func(data string) string {
+	if strings.HasSuffix(data, "2") {
+		panic("signer failed on " + data)
+	}
+	return "s" + data
+})
+
+func TestPanicInSigner(t *testing.T) {
+	RegisterSigner("panicky", panicSigner)
+	defer func(single, multi string, policy PanicPolicy, ch chan *StageError) {
+		SingleHashExpr, MultiHashExpr = single, multi
+		PipelinePanicPolicy, PipelineDeadLetter = policy, ch
+	}(SingleHashExpr, MultiHashExpr, PipelinePanicPolicy, PipelineDeadLetter)
+	SingleHashExpr, MultiHashExpr = "panicky(data)", "panicky(data)"
+
+	for _, stage := range []job{SingleHash, MultiHash} {
+		result := ""
+		jobs := panicJobs(&result)
+		jobs[0] = job(func(in, out chan interface{}) {
+			for _, v := range []int{1, 2, 3} {
+				out <- v
+			}
+		})
+		jobs[1] = stage
+
+		PipelinePanicPolicy = AbortOnPanic
+		err := RunPipeline(jobs...)
+		stageErr := &StageError{}
+		if !errors.As(err, &stageErr) || stageErr.Stage != 1 || stageErr.Item != 2 {
+			t.Fatalf("expected *StageError on item 2, got %v", err)
+		}
+		if msg, _ := stageErr.Panic.(string); !strings.HasPrefix(msg, "signer failed") || !strings.Contains(string(stageErr.Stack), "panic_test.go") {
+			t.Errorf("panic and stack must point to the signer: %v\n%s", stageErr.Panic, stageErr.Stack)
+		}
+
+		PipelinePanicPolicy = DeadLetterOnPanic
+		PipelineDeadLetter = make(chan *StageError, 10)
+		if err := RunPipeline(jobs...); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if strings.Count(result, "_") != 1 || len(PipelineDeadLetter) != 1 {
+			t.Errorf("expected 2 results and 1 dead letter, got %q and %d", result, len(PipelineDeadLetter))
+		}
+		if letter := <-PipelineDeadLetter; letter.Item != 2 {
+			t.Errorf("unexpected dead letter %v", letter)
+		}
+	}
+}
+
+func TestPanicDeadLetterWithoutChannel(t *testing.T) {
+	defer useFakeSigners()()
+	defer func(policy PanicPolicy, ch chan *StageError) {
+		PipelinePanicPolicy, PipelineDeadLetter = policy, ch
+	}(PipelinePanicPolicy, PipelineDeadLetter)
+	PipelinePanicPolicy, PipelineDeadLetter = DeadLetterOnPanic, nil
+
+	// конвейеры идут одновременно, каждый получает только свою ошибку
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := ""
+			err := RunPipeline(panicJobs(&result)...)
+			stageErr := &StageError{}
+			if !errors.As(err, &stageErr) || stageErr.Item != 2.5 {
+				t.Errorf("expected dropped dead letter as error, got %v", err)
+			}
+			if result != "c1~cm1_c3~cm3" {
+				t.Errorf("pipeline must go on without the item\nGot: %v", result)
+			}
+		}()
+	}
+	wg.Wait()
+}
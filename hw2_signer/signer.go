@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"sort"
 	"strconv"
 	"strings"
@@ -32,98 +33,199 @@ func ToStringCustom(input interface{}) string {
 }
 
 func SingleHash(in, out chan interface{}) {
+	formula := MustParseSignFormula(SingleHashExpr)
 	wg := &sync.WaitGroup{}
+	defer wg.Wait()
 
 	for dataRaw := range in {
-		dataStr := ToStringCustom(dataRaw)
+		dataStr, ok := itemString(in, dataRaw)
+		if !ok {
+			continue
+		}
 		wg.Add(1)
 
-		go func(data string, wgInt *sync.WaitGroup) {
+		go func(item interface{}, data string, id uint64, wgInt *sync.WaitGroup) {
 			defer wgInt.Done()
-			left := make(chan string)
-			right := make(chan string)
-
-			go Crc32Internal(data, left)
-			go Crc32Internal(Md5Internal(data), right)
-
-			result := ""
-			fromLeft := false
-			fromRight := false
-		LOOP:
-			for {
-				select {
-				case l := <-left:
-					fromLeft = true
-					result = l + "~" + result
-				case r := <-right:
-					fromRight = true
-					result = result + r
-				default:
-					if fromLeft && fromRight {
-						break LOOP
-					}
-				}
-			}
+			defer recoverItem(in, item)
+			end := PipelineTrace.span(id, "SingleHash", nil)
+			result := formula.signTraced(id, data)
+			end()
+			PipelineTrace.bind(result, id)
 			out <- result
-		}(dataStr, wg)
+		}(dataRaw, dataStr, PipelineTrace.take(dataStr), wg)
 	}
-
-	wg.Wait()
 }
 
 func MultiHash(in, out chan interface{}) {
+	formula := MustParseSignFormula(MultiHashExpr)
 	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+
 	for dataRaw := range in {
-		dataStr := ToStringCustom(dataRaw)
+		dataStr, ok := itemString(in, dataRaw)
+		if !ok {
+			continue
+		}
 		wg.Add(1)
 
-		go func(data string, wgInt *sync.WaitGroup) {
+		go func(item interface{}, data string, id uint64, wgInt *sync.WaitGroup) {
 			defer wgInt.Done()
-
-			var chans [6]chan string
-			for i := range chans {
-				chans[i] = make(chan string, 1)
-				go Crc32Internal(strconv.Itoa(i)+data, chans[i])
-			}
-			result := ""
-			for i := range chans {
-				val := <-chans[i]
-				result = result + val
-			}
-
+			defer recoverItem(in, item)
+			end := PipelineTrace.span(id, "MultiHash", nil)
+			result := multiHashSign(formula, id, data)
+			end()
+			PipelineTrace.bind(result, id)
 			out <- result
-		}(dataStr, wg)
+		}(dataRaw, dataStr, PipelineTrace.take(dataStr), wg)
 	}
-	wg.Wait()
+}
+
+// multiHashSign считает подписи th+data для th=0..5 параллельно и склеивает их по порядку
+func multiHashSign(formula SignFormula, id uint64, data string) string {
+	chans := make([]chan interface{}, 6)
+	for i := range chans {
+		th := i
+		chans[i] = goSign(func() string {
+			return formula.signTraced(id, strconv.Itoa(th)+data)
+		})
+	}
+	return strings.Join(awaitSign(chans), "")
 }
 
 func CombineResults(in, out chan interface{}) {
 	values := []string{}
 	for dataRaw := range in {
-		dataStr := ToStringCustom(dataRaw)
+		dataStr, ok := combineItem(in, dataRaw)
+		if !ok {
+			continue
+		}
 		values = append(values, dataStr)
 	}
+	out <- combineValues(values)
+}
+
+func combineItem(in chan interface{}, dataRaw interface{}) (string, bool) {
+	dataStr, ok := itemString(in, dataRaw)
+	if ok {
+		PipelineTrace.span(PipelineTrace.take(dataStr), "CombineResults", nil)()
+	}
+	return dataStr, ok
+}
+
+func combineValues(values []string) string {
+	defer PipelineTrace.span(0, "CombineResults sort", map[string]interface{}{"items": len(values)})()
 	sort.Strings(values)
-	result := strings.Join(values, "_")
-	out <- result
+	return strings.Join(values, "_")
 }
 
+// ExecutePipeline запускает стадии конвейером; если стадия запаниковала при AbortOnPanic
+// или не сохранился чекпоинт, ошибка RunPipeline поднимается паникой в вызывающей горутине
 func ExecutePipeline(jobs ...job) {
+	if err := RunPipeline(jobs...); err != nil {
+		panic(err)
+	}
+}
+
+// RunPipeline - ExecutePipeline, который возвращает панику стадии как *StageError,
+// а если стадии отработали - ошибку сохранения PipelineCheckpoint
+func RunPipeline(jobs ...job) (err error) {
+	run := newPipelineRun()
+	defer run.finish()
+	trace := PipelineTrace
+	cp := PipelineCheckpoint
+	if cp != nil {
+		// чекпоинт находит элемент на каждой стадии по его trace id
+		if trace == nil {
+			trace = NewTrace()
+			PipelineTrace = trace
+			defer func() {
+				PipelineTrace = nil
+			}()
+		}
+		stop := cp.start(len(jobs))
+		defer func() {
+			// ошибка стадии важнее ошибки сохранения
+			if saveErr := stop(); err == nil {
+				err = saveErr
+			}
+		}()
+	}
+
 	var previous chan interface{}
 	for i, j := range jobs {
 		out := make(chan interface{}, 1)
 
+		stage := run.stage(i)
+		if previous != nil {
+			previous = linkStages(run, stage, trace, cp, i-1, previous)
+			stage.register(previous)
+		}
+
 		if i == len(jobs)-1 {
-			j(previous, out)
+			stage.run(j, previous, out)
 			close(out)
-			return
+			return run.result()
 		}
 
-		go func(jobInt job, i, o chan interface{}) {
-			jobInt(i, o)
+		go func(s *stageRun, jobInt job, i, o chan interface{}) {
+			s.run(jobInt, i, o)
 			close(o)
-		}(j, previous, out)
+		}(stage, j, previous, out)
 
 		previous = out
 	}
+	return nil
+}
+
+// linkStages встает между стадиями: передает trace id элемента дальше по конвейеру,
+// при включенном чекпоинте сохраняет промежуточные значения и подмешивает восстановленные,
+// а после остановки конвейера дочитывает вход, чтобы не держать предыдущие стадии
+func linkStages(run *pipelineRun, next *stageRun, trace *Trace, cp *Checkpoint, stage int, in chan interface{}) chan interface{} {
+	out := make(chan interface{})
+	inject := cp.injectChan(stage)
+	go func() {
+		defer close(out)
+		if stage == 0 {
+			defer cp.closeInjects()
+		}
+		for in != nil || inject != nil {
+			var v interface{}
+			var id uint64
+			select {
+			case raw, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				v = raw
+				if run.aborted() {
+					continue
+				}
+				if stage == 0 {
+					if cp.resume(trace, v) {
+						continue
+					}
+					id = trace.newItem(v)
+				} else {
+					id = trace.takeOutput(v)
+				}
+			case item, ok := <-inject:
+				if !ok {
+					inject = nil
+					continue
+				}
+				v, id = item.value, item.id
+			}
+			cp.record(trace, id, stage, v)
+			end := trace.span(id, fmt.Sprintf("handoff %d->%d", stage, stage+1), nil)
+			trace.bindInput(v, id)
+			select {
+			case out <- v:
+				next.deliver(v)
+			case <-run.abort:
+			}
+			end()
+		}
+	}()
+	return out
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Signer считает подпись от строки
+type Signer interface {
+	Sign(data string) string
+}
+
+type SignerFunc func(data string) string
+
+func (f SignerFunc) Sign(data string) string {
+	return f(data)
+}
+
+// состав подписей SingleHash и MultiHash, можно переопределить через окружение
+var (
+	SingleHashExpr = "crc32(data)~crc32(md5(data))"
+	MultiHashExpr  = "crc32(data)"
+)
+
+var signersMutex = &sync.RWMutex{}
+
+// crc32 и md5 вызывают глобальные DataSigner* в момент подписи, чтобы их можно было подменить
+var signers = map[string]Signer{
+	"crc32":       SignerFunc(func(data string) string { return DataSignerCrc32(data) }),
+	"md5":         md5Signer{},
+	"sha256":      SignerFunc(Sha256Internal),
+	"sha512":      SignerFunc(Sha512Internal),
+	"hmac_sha256": SignerFunc(HmacSha256Internal),
+}
+
+func init() {
+	if expr := os.Getenv("SIGNER_SINGLE_HASH"); expr != "" {
+		SingleHashExpr = expr
+	}
+	if expr := os.Getenv("SIGNER_MULTI_HASH"); expr != "" {
+		MultiHashExpr = expr
+	}
+}
+
+// tracedSigner сам пишет спаны в трассу, например ожидание блокировки md5
+type tracedSigner interface {
+	signTraced(id uint64, data string) string
+}
+
+type md5Signer struct{}
+
+func (md5Signer) Sign(data string) string {
+	return Md5Internal(data)
+}
+
+func (md5Signer) signTraced(id uint64, data string) string {
+	endWait := PipelineTrace.span(id, "md5 lock wait", nil)
+	mutex.Lock()
+	endWait()
+	defer mutex.Unlock()
+	defer PipelineTrace.span(id, "md5", nil)()
+	return DataSignerMd5(data)
+}
+
+func Sha256Internal(data string) string {
+	sum := sha256.Sum256([]byte(data + DataSignerSalt))
+	return hex.EncodeToString(sum[:])
+}
+
+func Sha512Internal(data string) string {
+	sum := sha512.Sum512([]byte(data + DataSignerSalt))
+	return hex.EncodeToString(sum[:])
+}
+
+// HmacSha256Internal использует соль как ключ
+func HmacSha256Internal(data string) string {
+	mac := hmac.New(sha256.New, []byte(DataSignerSalt))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func RegisterSigner(name string, signer Signer) {
+	signersMutex.Lock()
+	signers[name] = signer
+	signersMutex.Unlock()
+}
+
+func LookupSigner(name string) (Signer, bool) {
+	signersMutex.RLock()
+	signer, ok := signers[name]
+	signersMutex.RUnlock()
+	return signer, ok
+}
+
+// signTerm - цепочка вложенных вызовов, например crc32(md5(data)); signer == nil означает сами данные
+type signTerm struct {
+	name   string
+	signer Signer
+	arg    *signTerm
+}
+
+func (term *signTerm) Sign(data string) string {
+	return term.signTraced(0, data)
+}
+
+func (term *signTerm) signTraced(id uint64, data string) string {
+	if term.signer == nil {
+		return data
+	}
+	arg := term.arg.signTraced(id, data)
+	if traced, ok := term.signer.(tracedSigner); ok {
+		return traced.signTraced(id, arg)
+	}
+	defer PipelineTrace.span(id, term.name, nil)()
+	return term.signer.Sign(arg)
+}
+
+func (term *signTerm) String() string {
+	if term.signer == nil {
+		return "data"
+	}
+	return term.name + "(" + term.arg.String() + ")"
+}
+
+// SignFormula - подписи, которые считаются параллельно и склеиваются через ~
+type SignFormula []*signTerm
+
+func ParseSignFormula(expr string) (SignFormula, error) {
+	result := SignFormula{}
+	for _, part := range strings.Split(expr, "~") {
+		term, err := parseSignTerm(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("bad sign formula %q: %s", expr, err)
+		}
+		result = append(result, term)
+	}
+	return result, nil
+}
+
+func MustParseSignFormula(expr string) SignFormula {
+	formula, err := ParseSignFormula(expr)
+	if err != nil {
+		panic(err)
+	}
+	return formula
+}
+
+func parseSignTerm(expr string) (*signTerm, error) {
+	if expr == "data" {
+		return &signTerm{}, nil
+	}
+
+	open := strings.IndexByte(expr, '(')
+	if open <= 0 || !strings.HasSuffix(expr, ")") {
+		return nil, fmt.Errorf("cant parse %q", expr)
+	}
+
+	name := strings.TrimSpace(expr[:open])
+	signer, ok := LookupSigner(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown signer %q", name)
+	}
+
+	arg, err := parseSignTerm(strings.TrimSpace(expr[open+1 : len(expr)-1]))
+	if err != nil {
+		return nil, err
+	}
+	return &signTerm{name: name, signer: signer, arg: arg}, nil
+}
+
+func (formula SignFormula) Sign(data string) string {
+	return formula.signTraced(0, data)
+}
+
+func (formula SignFormula) signTraced(id uint64, data string) string {
+	var chans = make([]chan interface{}, len(formula))
+	for i, term := range formula {
+		t := term
+		chans[i] = goSign(func() string {
+			return t.signTraced(id, data)
+		})
+	}
+	return strings.Join(awaitSign(chans), "~")
+}
+
+func (formula SignFormula) String() string {
+	parts := make([]string, len(formula))
+	for i, term := range formula {
+		parts[i] = term.String()
+	}
+	return strings.Join(parts, "~")
+}
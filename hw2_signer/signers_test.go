@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestParseSignFormula(t *testing.T) {
+	cases := []string{
+		"crc32(data)~crc32(md5(data))",
+		"sha256(data)~sha256(md5(data))",
+		"hmac_sha256(sha512(data))",
+		"data",
+	}
+	for _, expr := range cases {
+		formula, err := ParseSignFormula(expr)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", expr, err)
+			continue
+		}
+		if formula.String() != expr {
+			t.Errorf("formula not match\nGot: %v\nExpected: %v", formula.String(), expr)
+		}
+	}
+
+	bad := []string{"", "sha1(data)", "sha256(data", "sha256()", "(data)"}
+	for _, expr := range bad {
+		if _, err := ParseSignFormula(expr); err == nil {
+			t.Errorf("formula %q must not be accepted", expr)
+		}
+	}
+}
+
+func TestSignerComposition(t *testing.T) {
+	sha := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	}
+
+	defer func(single, multi string) {
+		SingleHashExpr, MultiHashExpr = single, multi
+	}(SingleHashExpr, MultiHashExpr)
+	SingleHashExpr = "sha256(data)~sha256(sha512(data))"
+	MultiHashExpr = "sha256(data)"
+
+	step1 := sha("7") + "~" + sha(Sha512Internal("7"))
+	expected := ""
+	for _, th := range []string{"0", "1", "2", "3", "4", "5"} {
+		expected += sha(th + step1)
+	}
+
+	result := ""
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			out <- 7
+		}),
+		job(SingleHash),
+		job(MultiHash),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				result = val.(string)
+			}
+		}),
+	)
+
+	if result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+}
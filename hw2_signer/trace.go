@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PipelineTrace включает трассировку ExecutePipeline, nil - выключена
+var PipelineTrace *Trace
+
+// Trace собирает спаны по элементам конвейера, каждый элемент получает свой trace id
+type Trace struct {
+	mu     sync.Mutex
+	start  time.Time
+	nextID uint64
+	// значение элемента -> id, очередь потому что значения могут повторяться
+	ids    map[string][]uint64
+	names  map[uint64]string
+	events []traceEvent
+}
+
+// traceEvent - событие в формате Chrome trace-event
+type traceEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Phase string                 `json:"ph"`
+	Ts    int64                  `json:"ts"`
+	Dur   int64                  `json:"dur,omitempty"`
+	Pid   int                    `json:"pid"`
+	Tid   uint64                 `json:"tid"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+func NewTrace() *Trace {
+	return &Trace{
+		start: time.Now(),
+		ids:   make(map[string][]uint64),
+		names: make(map[uint64]string),
+	}
+}
+
+func traceKey(value interface{}) string {
+	return fmt.Sprint(value)
+}
+
+// newItem выдает новый trace id элементу, вошедшему в конвейер
+func (t *Trace) newItem(value interface{}) uint64 {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	t.names[t.nextID] = traceKey(value)
+	return t.nextID
+}
+
+// name возвращает входное значение, с которым элемент id вошел в конвейер
+func (t *Trace) name(id uint64) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.names[id]
+}
+
+// bind связывает результат стадии с id элемента, из которого он получен
+func (t *Trace) bind(value interface{}, id uint64) {
+	t.push("out", value, id)
+}
+
+// take находит id элемента, пришедшего на вход стадии, если не нашли - это новый элемент
+func (t *Trace) take(value interface{}) uint64 {
+	return t.pop("in", value)
+}
+
+// bindInput и takeOutput - то же самое со стороны перехода между стадиями. Входы и выходы
+// связываются отдельно, иначе одинаковые значения на разных стадиях путают id
+func (t *Trace) bindInput(value interface{}, id uint64) {
+	t.push("in", value, id)
+}
+
+func (t *Trace) takeOutput(value interface{}) uint64 {
+	return t.pop("out", value)
+}
+
+func (t *Trace) push(side string, value interface{}, id uint64) {
+	if t == nil || id == 0 {
+		return
+	}
+	t.mu.Lock()
+	key := side + "/" + traceKey(value)
+	t.ids[key] = append(t.ids[key], id)
+	t.mu.Unlock()
+}
+
+func (t *Trace) pop(side string, value interface{}) uint64 {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	key := side + "/" + traceKey(value)
+	if queue := t.ids[key]; len(queue) > 0 {
+		id := queue[0]
+		if len(queue) == 1 {
+			delete(t.ids, key)
+		} else {
+			t.ids[key] = queue[1:]
+		}
+		t.mu.Unlock()
+		return id
+	}
+	t.mu.Unlock()
+	return t.newItem(value)
+}
+
+// span начинает спан, вызов возвращенной функции его завершает
+func (t *Trace) span(id uint64, name string, args map[string]interface{}) func() {
+	if t == nil {
+		return func() {}
+	}
+	begin := time.Now()
+	return func() {
+		end := time.Now()
+		t.mu.Lock()
+		t.events = append(t.events, traceEvent{
+			Name:  name,
+			Cat:   "pipeline",
+			Phase: "X",
+			Ts:    begin.Sub(t.start).Microseconds(),
+			Dur:   end.Sub(begin).Microseconds(),
+			Pid:   1,
+			Tid:   id,
+			Args:  args,
+		})
+		t.mu.Unlock()
+	}
+}
+
+// Spans возвращает имена спанов элемента в порядке начала
+func (t *Trace) Spans(id uint64) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	events := t.sortedEvents()
+	result := []string{}
+	for _, e := range events {
+		if e.Tid == id {
+			result = append(result, e.Name)
+		}
+	}
+	return result
+}
+
+// Items возвращает число элементов, получивших trace id
+func (t *Trace) Items() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return int(t.nextID)
+}
+
+func (t *Trace) sortedEvents() []traceEvent {
+	events := make([]traceEvent, len(t.events))
+	copy(events, t.events)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Ts < events[j].Ts
+	})
+	return events
+}
+
+// WriteChromeJSON выгружает трассу в формате, который открывается в chrome://tracing и Perfetto
+func (t *Trace) WriteChromeJSON(w io.Writer) error {
+	t.mu.Lock()
+	events := []traceEvent{{
+		Name:  "thread_name",
+		Phase: "M",
+		Pid:   1,
+		Args:  map[string]interface{}{"name": "pipeline"},
+	}}
+	for id := uint64(1); id <= t.nextID; id++ {
+		events = append(events, traceEvent{
+			Name:  "thread_name",
+			Phase: "M",
+			Pid:   1,
+			Tid:   id,
+			Args:  map[string]interface{}{"name": fmt.Sprintf("item %d (%s)", id, t.names[id])},
+		})
+	}
+	events = append(events, t.sortedEvents()...)
+	t.mu.Unlock()
+
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{events, "ms"})
+}
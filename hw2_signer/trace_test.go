@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPipelineTrace(t *testing.T) {
+	defer func(md5, crc32 func(string) string) {
+		DataSignerMd5, DataSignerCrc32 = md5, crc32
+		PipelineTrace = nil
+	}(DataSignerMd5, DataSignerCrc32)
+	DataSignerMd5 = func(data string) string { return "md5" + data }
+	DataSignerCrc32 = func(data string) string { return "crc" + data }
+
+	PipelineTrace = NewTrace()
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, v := range []int{1, 1, 2} {
+				out <- v
+			}
+		}),
+		job(SingleHash),
+		job(MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			for range in {
+			}
+		}),
+	)
+
+	if PipelineTrace.Items() < 3 {
+		t.Fatalf("expected trace ids for 3 items, got %d", PipelineTrace.Items())
+	}
+
+	for id := uint64(1); id <= 3; id++ {
+		spans := strings.Join(PipelineTrace.Spans(id), ",")
+		for _, name := range []string{"handoff 0->1", "SingleHash", "md5 lock wait", "md5", "crc32", "MultiHash", "CombineResults"} {
+			if !strings.Contains(spans, name) {
+				t.Errorf("item %d has no span %q: %s", id, name, spans)
+			}
+		}
+		if strings.Count(spans, "crc32") != 8 {
+			t.Errorf("item %d expected 8 crc32 spans: %s", id, spans)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := PipelineTrace.WriteChromeJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	dump := struct {
+		TraceEvents []map[string]interface{} `json:"traceEvents"`
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &dump); err != nil {
+		t.Fatalf("cant unpack trace json: %s", err)
+	}
+	if len(dump.TraceEvents) == 0 {
+		t.Errorf("empty trace")
+	}
+}
+
+// одинаковое значение на выходе стадии и на входе следующей не должно путать id
+func TestPipelineTraceRepeatedValues(t *testing.T) {
+	defer func() { PipelineTrace = nil }()
+	PipelineTrace = NewTrace()
+
+	received := make(chan struct{})
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			out <- "x"
+			out <- "x"
+		}),
+		job(func(in, out chan interface{}) {
+			first := true
+			for v := range in {
+				PipelineTrace.bind("y", PipelineTrace.take(v))
+				out <- "y"
+				// второй "y" уходит, когда первый уже передан дальше, но еще не взят стадией
+				if first {
+					<-received
+					first = false
+				}
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			values := []interface{}{<-in}
+			close(received)
+			for v := range in {
+				values = append(values, v)
+			}
+			for _, v := range values {
+				PipelineTrace.span(PipelineTrace.take(v), "sink", nil)()
+			}
+		}),
+	)
+
+	for id := uint64(1); id <= 2; id++ {
+		spans := strings.Join(PipelineTrace.Spans(id), ",")
+		if strings.Count(spans, "handoff 1->2") != 1 || strings.Count(spans, "sink") != 1 {
+			t.Errorf("item %d must pass each stage once: %s", id, spans)
+		}
+	}
+}
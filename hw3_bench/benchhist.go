@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// метрики, которые снимает go test -bench . -benchmem
+var benchUnits = []string{"ns/op", "B/op", "allocs/op"}
+
+// BenchRun - один запуск бенчмарков: имя бенчмарка -> единица -> замеры по -count
+type BenchRun struct {
+	Label   string                          `json:"label"`
+	Time    time.Time                       `json:"time"`
+	Results map[string]map[string][]float64 `json:"results"`
+}
+
+// BenchHistory - все запуски по порядку, Baseline - метка запуска, с которым сравниваем
+type BenchHistory struct {
+	Baseline string      `json:"baseline,omitempty"`
+	Runs     []*BenchRun `json:"runs"`
+}
+
+// строка результата, у имени отрезаем суффикс -GOMAXPROCS
+var benchLine = regexp.MustCompile(`^(Benchmark\S+?)(?:-\d+)?\s+\d+\s+(.*)$`)
+
+// ParseBenchOutput собирает замеры из вывода go test -bench
+func ParseBenchOutput(r io.Reader) (map[string]map[string][]float64, error) {
+	results := make(map[string]map[string][]float64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m := benchLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		fields := strings.Fields(m[2])
+		for i := 0; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad value %q in %s", fields[i], m[1])
+			}
+			if results[m[1]] == nil {
+				results[m[1]] = make(map[string][]float64)
+			}
+			results[m[1]][fields[i+1]] = append(results[m[1]][fields[i+1]], value)
+		}
+	}
+	return results, scanner.Err()
+}
+
+// LoadBenchHistory читает историю, если файла нет - история пустая
+func LoadBenchHistory(path string) (*BenchHistory, error) {
+	h := &BenchHistory{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("broken history %s: %s", path, err)
+	}
+	return h, nil
+}
+
+func (h *BenchHistory) Save(path string) error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Find ищет запуск по метке, начиная с последнего
+func (h *BenchHistory) Find(label string) *BenchRun {
+	for i := len(h.Runs) - 1; i >= 0; i-- {
+		if h.Runs[i].Label == label {
+			return h.Runs[i]
+		}
+	}
+	return nil
+}
+
+// BaselineFor - запуск, с которым сравнивается run: отмеченный как Baseline,
+// а если его нет - предыдущий
+func (h *BenchHistory) BaselineFor(run *BenchRun) *BenchRun {
+	if h.Baseline != "" {
+		if base := h.Find(h.Baseline); base != nil && base != run {
+			return base
+		}
+	}
+	for i := len(h.Runs) - 1; i >= 0; i-- {
+		if h.Runs[i] != run {
+			return h.Runs[i]
+		}
+	}
+	return nil
+}
+
+// BenchDelta - сравнение одной метрики одного бенчмарка
+type BenchDelta struct {
+	Name string
+	Unit string
+	Old  []float64
+	New  []float64
+	// изменение среднего в процентах, больше нуля - хуже
+	Change float64
+	P      float64
+}
+
+func (d *BenchDelta) Significant(alpha float64) bool {
+	return d.P < alpha
+}
+
+// CompareBenchRuns сравнивает метрики, которые есть в обоих запусках
+func CompareBenchRuns(base, run *BenchRun) []*BenchDelta {
+	names := []string{}
+	for name := range run.Results {
+		if base.Results[name] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	deltas := []*BenchDelta{}
+	for _, name := range names {
+		for _, unit := range benchUnits {
+			old, cur := base.Results[name][unit], run.Results[name][unit]
+			if len(old) == 0 || len(cur) == 0 {
+				continue
+			}
+			d := &BenchDelta{Name: name, Unit: unit, Old: old, New: cur, P: mannWhitneyP(old, cur)}
+			if oldMean := mean(old); oldMean != 0 {
+				d.Change = (mean(cur) - oldMean) / oldMean * 100
+			} else if mean(cur) != 0 {
+				d.Change = math.Inf(1)
+			}
+			deltas = append(deltas, d)
+		}
+	}
+	return deltas
+}
+
+// BenchThresholds - допустимое ухудшение в процентах
+type BenchThresholds struct {
+	Time  float64
+	Alloc float64
+	Alpha float64
+}
+
+// Regressions - значимые ухудшения сверх порогов. Порог Alloc действует на B/op и allocs/op
+func (t BenchThresholds) Regressions(deltas []*BenchDelta) []*BenchDelta {
+	result := []*BenchDelta{}
+	for _, d := range deltas {
+		limit := t.Alloc
+		if d.Unit == "ns/op" {
+			limit = t.Time
+		}
+		if d.Change > limit && d.Significant(t.Alpha) {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// WriteBenchDeltas печатает сравнение в духе benchstat
+func WriteBenchDeltas(out io.Writer, deltas []*BenchDelta, t BenchThresholds) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "name\tunit\told\tnew\tdelta\t")
+	for _, d := range deltas {
+		delta := "~"
+		if d.Significant(t.Alpha) {
+			delta = fmt.Sprintf("%+.2f%%", d.Change)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t(p=%.3f n=%d+%d)\n",
+			d.Name, d.Unit, formatSamples(d.Old), formatSamples(d.New), delta, d.P, len(d.Old), len(d.New))
+	}
+	return w.Flush()
+}
+
+// formatSamples - среднее и разброс в процентах от него
+func formatSamples(samples []float64) string {
+	m := mean(samples)
+	spread := 0.0
+	if m != 0 {
+		for _, v := range samples {
+			spread = math.Max(spread, math.Abs(v-m)/m*100)
+		}
+	}
+	return fmt.Sprintf("%.4g ±%2.0f%%", m, spread)
+}
+
+func mean(samples []float64) float64 {
+	sum := 0.0
+	for _, v := range samples {
+		sum += v
+	}
+	return sum / float64(len(samples))
+}
+
+// mannWhitneyP - двусторонний p-value U-критерия Манна-Уитни в нормальном
+// приближении с поправкой на связки, как в benchstat. Если обе выборки из нескольких
+// одинаковых значений (обычно allocs/op), разные выборки считаются различимыми точно
+func mannWhitneyP(a, b []float64) float64 {
+	n1, n2 := float64(len(a)), float64(len(b))
+	type sample struct {
+		value float64
+		fromA bool
+	}
+	all := make([]sample, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range b {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+
+	// ранги со средним для равных значений
+	rankA, ties := 0.0, 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankA += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	if ties == math.Pow(n1+n2, 3)-(n1+n2) {
+		return 1
+	}
+	if len(a) > 1 && len(b) > 1 && constant(a) && constant(b) {
+		return 0
+	}
+
+	u := rankA - n1*(n1+1)/2
+	mu := n1 * n2 / 2
+	n := n1 + n2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1))))
+	z := math.Max(math.Abs(u-mu)-0.5, 0) / sigma
+	return math.Erfc(z / math.Sqrt2)
+}
+
+func constant(samples []float64) bool {
+	for _, v := range samples {
+		if v != samples[0] {
+			return false
+		}
+	}
+	return true
+}
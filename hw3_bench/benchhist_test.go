@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const benchOutput = `goos: linux
+goarch: amd64
+pkg: coursera/hw3
+BenchmarkSlow-8   	      10	 142703250 ns/op	336887900 B/op	  284175 allocs/op
+BenchmarkFast-8   	     500	   2782432 ns/op	  559910 B/op	   10422 allocs/op
+BenchmarkFast-8   	     500	   2790000 ns/op	  559910 B/op	   10422 allocs/op
+BenchmarkFastParallel/4-8	     300	   3100000 ns/op	  600000 B/op	   10500 allocs/op
+PASS
+ok  	coursera/hw3	3.897s
+`
+
+func TestParseBenchOutput(t *testing.T) {
+	results, err := ParseBenchOutput(strings.NewReader(benchOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 benchmarks, got %v", results)
+	}
+	fast := results["BenchmarkFast"]
+	if len(fast["ns/op"]) != 2 || fast["ns/op"][1] != 2790000 || fast["allocs/op"][0] != 10422 {
+		t.Errorf("unexpected BenchmarkFast samples %v", fast)
+	}
+	if results["BenchmarkFastParallel/4"] == nil {
+		t.Errorf("sub-benchmark name lost: %v", results)
+	}
+}
+
+func benchRun(label string, ns, allocs []float64) *BenchRun {
+	return &BenchRun{Label: label, Results: map[string]map[string][]float64{
+		"BenchmarkFast": {"ns/op": ns, "allocs/op": allocs},
+	}}
+}
+
+func TestBenchRegressions(t *testing.T) {
+	thresholds := BenchThresholds{Time: 5, Alloc: 0, Alpha: 0.05}
+	base := benchRun("base", []float64{100, 102, 98, 101, 99}, []float64{142, 142, 142, 142, 142})
+
+	cases := []struct {
+		run      *BenchRun
+		expected []string
+	}{
+		// шум в пределах разброса
+		{benchRun("noise", []float64{101, 97, 103, 100, 99}, []float64{142, 142, 142, 142, 142}), nil},
+		// значимо медленнее, но меньше порога
+		{benchRun("slightly", []float64{103, 104, 103, 105, 104}, []float64{142, 142, 142, 142, 142}), nil},
+		{benchRun("slower", []float64{120, 118, 121, 119, 122}, []float64{142, 142, 142, 142, 142}), []string{"ns/op"}},
+		{benchRun("allocs", []float64{100, 101, 99, 100, 100}, []float64{143, 143, 143, 143, 143}), []string{"allocs/op"}},
+		// одного замера мало, чтобы что-то утверждать
+		{benchRun("single", []float64{150}, []float64{142}), nil},
+		{benchRun("faster", []float64{50, 51, 49, 50, 52}, []float64{100, 100, 100, 100, 100}), nil},
+	}
+	for _, c := range cases {
+		got := []string{}
+		for _, d := range thresholds.Regressions(CompareBenchRuns(base, c.run)) {
+			got = append(got, d.Unit)
+		}
+		if strings.Join(got, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("%s: expected regressions %v, got %v", c.run.Label, c.expected, got)
+		}
+	}
+
+	out := new(bytes.Buffer)
+	WriteBenchDeltas(out, CompareBenchRuns(base, cases[0].run), thresholds)
+	if !strings.Contains(out.String(), "BenchmarkFast  ns/op") || !strings.Contains(out.String(), "~") {
+		t.Errorf("unexpected comparison:\n%s", out)
+	}
+}
+
+func TestBenchHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	h, err := LoadBenchHistory(path)
+	if err != nil || len(h.Runs) != 0 {
+		t.Fatalf("expected empty history, got %v %v", h, err)
+	}
+
+	first := benchRun("first", []float64{100}, []float64{142})
+	second := benchRun("second", []float64{100}, []float64{142})
+	h.Runs = append(h.Runs, first, second)
+	h.Baseline = "first"
+	if err := h.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err = LoadBenchHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third := benchRun("third", []float64{100}, []float64{142})
+	h.Runs = append(h.Runs, third)
+	if base := h.BaselineFor(third); base == nil || base.Label != "first" {
+		t.Errorf("expected baseline first, got %+v", base)
+	}
+	h.Baseline = ""
+	if base := h.BaselineFor(third); base == nil || base.Label != "second" {
+		t.Errorf("expected previous run as baseline, got %+v", base)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	jlexer "github.com/mailru/easyjson/jlexer"
+)
+
+// Колоночный формат пользователей, все числа little endian:
+//
+//	magic "USRCOLS1"
+//	uint32 число пользователей, uint32 размер словаря браузеров, uint32 число секций
+//	секции: uint32 id, uint64 смещение от начала файла, uint64 длина
+//
+// Строковая колонка - (n+1) uint32 смещений в данных и сами данные подряд. Браузеры
+// хранятся словарем (секция browserDictSection, строковая колонка) и списками номеров
+// (секция fieldBrowsers: (n+1) uint32 смещений в списке номеров и номера по uint32)
+const columnarMagic = "USRCOLS1"
+
+const browserDictSection = 1 << 7
+
+const columnarOutBuffer = 16 * 1024
+
+// строковые колонки в порядке записи
+var columnarStrings = []fieldSet{fieldName, fieldEmail, fieldCompany, fieldCountry, fieldJob, fieldPhone}
+
+var le = binary.LittleEndian
+
+// columnBuilder копит строковую колонку при конвертации
+type columnBuilder struct {
+	offsets []byte
+	data    []byte
+}
+
+func newColumnBuilder() *columnBuilder {
+	return &columnBuilder{offsets: le.AppendUint32(nil, 0)}
+}
+
+func (b *columnBuilder) add(s string) {
+	b.data = append(b.data, s...)
+	b.offsets = le.AppendUint32(b.offsets, uint32(len(b.data)))
+}
+
+// ConvertColumnar переводит NDJSON пользователей из in в колоночный формат
+func ConvertColumnar(in io.Reader, out io.Writer) (int, error) {
+	r, err := maybeGzip(in)
+	if err != nil {
+		return 0, &ReadError{Line: 1, Err: err}
+	}
+
+	columns := make(map[fieldSet]*columnBuilder)
+	for _, f := range columnarStrings {
+		columns[f] = newColumnBuilder()
+	}
+	dict := newColumnBuilder()
+	dictIDs := make(map[string]uint32)
+	lists := le.AppendUint32(nil, 0)
+	ids := []byte{}
+
+	scanner := bufio.NewScanner(r)
+	u := &user{}
+	single := [1]string{}
+	n := 0
+	for ; scanner.Scan(); n++ {
+		*u = user{Browsers: u.Browsers[:0]}
+		lexer := jlexer.Lexer{Data: scanner.Bytes()}
+		easyjson9e1087fdDecodeFakeCom(&lexer, u, allFields, true)
+		if err := lexer.Error(); err != nil {
+			return n, &LineError{Line: n + 1, Err: err}
+		}
+		for _, f := range columnarStrings {
+			columns[f].add(f.values(u, &single)[0])
+		}
+		for _, browser := range u.Browsers {
+			id, ok := dictIDs[browser]
+			if !ok {
+				id = uint32(len(dictIDs))
+				dictIDs[strings.Clone(browser)] = id
+				dict.add(browser)
+			}
+			ids = le.AppendUint32(ids, id)
+		}
+		lists = le.AppendUint32(lists, uint32(len(ids)/4))
+	}
+	if err := scanner.Err(); err != nil {
+		return n, &ReadError{Line: n + 1, Err: err}
+	}
+
+	type section struct {
+		id    uint32
+		parts [][]byte
+	}
+	sections := []section{}
+	for _, f := range columnarStrings {
+		sections = append(sections, section{uint32(f), [][]byte{columns[f].offsets, columns[f].data}})
+	}
+	sections = append(sections,
+		section{browserDictSection, [][]byte{dict.offsets, dict.data}},
+		section{uint32(fieldBrowsers), [][]byte{lists, ids}},
+	)
+
+	header := []byte(columnarMagic)
+	header = le.AppendUint32(header, uint32(n))
+	header = le.AppendUint32(header, uint32(len(dictIDs)))
+	header = le.AppendUint32(header, uint32(len(sections)))
+	offset := uint64(len(header) + len(sections)*20)
+	for _, s := range sections {
+		length := uint64(0)
+		for _, part := range s.parts {
+			length += uint64(len(part))
+		}
+		header = le.AppendUint32(header, s.id)
+		header = le.AppendUint64(header, offset)
+		header = le.AppendUint64(header, length)
+		offset += length
+	}
+
+	w := bufio.NewWriter(out)
+	w.Write(header)
+	for _, s := range sections {
+		for _, part := range s.parts {
+			w.Write(part)
+		}
+	}
+	return n, w.Flush()
+}
+
+// stringColumn - строковая колонка поверх буфера файла
+type stringColumn struct {
+	offsets []byte
+	data    []byte
+}
+
+func parseStringColumn(section []byte, n int) (stringColumn, error) {
+	size := (n + 1) * 4
+	if len(section) < size {
+		return stringColumn{}, fmt.Errorf("column offsets truncated")
+	}
+	c := stringColumn{offsets: section[:size], data: section[size:]}
+	if int(le.Uint32(c.offsets[n*4:])) != len(c.data) {
+		return stringColumn{}, fmt.Errorf("column data size mismatch")
+	}
+	return c, nil
+}
+
+// get возвращает строку без копирования, она живет, пока жив буфер файла
+func (c *stringColumn) get(i int) string {
+	start, end := le.Uint32(c.offsets[i*4:]), le.Uint32(c.offsets[i*4+4:])
+	if start >= end || int(end) > len(c.data) {
+		return ""
+	}
+	return unsafe.String(&c.data[start], end-start)
+}
+
+// ColumnarFile - открытый колоночный файл, запросы к нему не разбирают JSON
+type ColumnarFile struct {
+	users   int
+	strings [8]stringColumn
+	dictLen int
+	dict    stringColumn
+	// номера браузеров пользователя i - ids[lists[i]:lists[i+1]]
+	lists []byte
+	ids   []byte
+}
+
+func OpenColumnar(path string) (*ColumnarFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseColumnar(data)
+}
+
+// ParseColumnar разбирает заголовок, колонки ссылаются на data
+func ParseColumnar(data []byte) (*ColumnarFile, error) {
+	const headerSize = len(columnarMagic) + 12
+	if len(data) < headerSize || string(data[:len(columnarMagic)]) != columnarMagic {
+		return nil, fmt.Errorf("not a columnar users file")
+	}
+	f := &ColumnarFile{users: int(le.Uint32(data[8:])), dictLen: int(le.Uint32(data[12:]))}
+	count := int(le.Uint32(data[16:]))
+	if len(data) < headerSize+count*20 {
+		return nil, fmt.Errorf("columnar header truncated")
+	}
+
+	found := fieldSet(0)
+	dictFound := false
+	for i := 0; i < count; i++ {
+		entry := data[headerSize+i*20:]
+		id := le.Uint32(entry)
+		offset, length := le.Uint64(entry[4:]), le.Uint64(entry[12:])
+		if offset > uint64(len(data)) || length > uint64(len(data))-offset {
+			return nil, fmt.Errorf("section %d out of file", id)
+		}
+		section := data[offset : offset+length]
+
+		var err error
+		switch {
+		case id == browserDictSection:
+			f.dict, err = parseStringColumn(section, f.dictLen)
+			dictFound = true
+		case fieldSet(id) == fieldBrowsers:
+			size := (f.users + 1) * 4
+			if len(section) < size || int(le.Uint32(section[f.users*4:]))*4 != len(section)-size {
+				return nil, fmt.Errorf("browsers column size mismatch")
+			}
+			f.lists, f.ids = section[:size], section[size:]
+		case id < browserDictSection && bits.OnesCount32(id) == 1:
+			f.strings[bits.TrailingZeros32(id)], err = parseStringColumn(section, f.users)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("section %d: %s", id, err)
+		}
+		found |= fieldSet(id)
+	}
+	if found&allFields != allFields || !dictFound {
+		return nil, fmt.Errorf("columnar file misses columns")
+	}
+	for i := 0; i < f.users; i++ {
+		if le.Uint32(f.lists[i*4:]) > le.Uint32(f.lists[i*4+4:]) {
+			return nil, fmt.Errorf("browsers of user %d are out of order", i)
+		}
+	}
+	for i := 0; i < len(f.ids); i += 4 {
+		if int(le.Uint32(f.ids[i:])) >= f.dictLen {
+			return nil, fmt.Errorf("browser id out of dictionary")
+		}
+	}
+	return f, nil
+}
+
+func (f *ColumnarFile) Users() int {
+	return f.users
+}
+
+func (f *ColumnarFile) column(field fieldSet) *stringColumn {
+	return &f.strings[bits.TrailingZeros8(uint8(field))]
+}
+
+// browserIDs - номера браузеров пользователя в виде сырых uint32
+func (f *ColumnarFile) browserIDs(idx int) []byte {
+	return f.ids[le.Uint32(f.lists[idx*4:])*4 : le.Uint32(f.lists[idx*4+4:])*4]
+}
+
+// Search выполняет запрос как SearchQuery. Условия по браузерам считаются один раз
+// на строку словаря, а не на каждого пользователя
+func (f *ColumnarFile) Search(q Query, out io.Writer) (Stats, error) {
+	stats := Stats{Lines: f.users}
+	query, err := compileQuery(q)
+	if err != nil {
+		return stats, err
+	}
+
+	dictLen := f.dictLen
+	// matched[leaf*dictLen+id] - подходит ли браузер id под лист leaf
+	matched := make([]bool, len(query.leaves)*dictLen)
+	leafFields := make([]fieldSet, len(query.leaves))
+	for li, leaf := range query.leaves {
+		leafFields[li] = fieldNames[leaf.Field]
+		if leafFields[li] != fieldBrowsers {
+			continue
+		}
+		for id := 0; id < dictLen; id++ {
+			matched[li*dictLen+id] = leaf.matchValue(f.dict.get(id))
+		}
+	}
+	// в словаре только встреченные браузеры, так что уникальные - подошедшие строки словаря
+	for id := 0; id < dictLen; id++ {
+		for li := range query.leaves {
+			if leafFields[li] == fieldBrowsers && matched[li*dictLen+id] {
+				stats.UniqueBrowsers++
+				break
+			}
+		}
+	}
+
+	// вывод копится в одном буфере вместо bufio.Writer - на аллокацию меньше
+	buf := make([]byte, 0, columnarOutBuffer)
+	buf = append(buf, "found users:\n"...)
+	state := &queryState{query: query, leafResults: make(map[*Expr]bool, len(query.leaves))}
+	u := &user{}
+	for idx := 0; idx < f.users; idx++ {
+		for li, leaf := range query.leaves {
+			result := false
+			if leafFields[li] == fieldBrowsers {
+				ids := f.browserIDs(idx)
+				for i := 0; i < len(ids) && !result; i += 4 {
+					result = matched[li*dictLen+int(le.Uint32(ids[i:]))]
+				}
+			} else {
+				result = leaf.matchValue(f.column(leafFields[li]).get(idx))
+			}
+			state.leafResults[leaf] = result
+		}
+		if query.where != nil && !state.eval(query.where) {
+			continue
+		}
+
+		stats.Found++
+		f.fill(u, idx, query.fields)
+		buf = query.appendLine(buf, idx, u)
+		if len(buf) > columnarOutBuffer*3/4 {
+			if _, err := out.Write(buf); err != nil {
+				return stats, err
+			}
+			buf = buf[:0]
+		}
+	}
+
+	buf = append(buf, "\nTotal unique browsers "...)
+	buf = strconv.AppendInt(buf, int64(stats.UniqueBrowsers), 10)
+	buf = append(buf, '\n')
+	_, err = out.Write(buf)
+	return stats, err
+}
+
+// fill заполняет у u только выводимые поля
+func (f *ColumnarFile) fill(u *user, idx int, fields []fieldSet) {
+	for _, field := range fields {
+		value := ""
+		if field != fieldBrowsers {
+			value = f.column(field).get(idx)
+		}
+		switch field {
+		case fieldName:
+			u.Name = value
+		case fieldEmail:
+			u.Email = value
+		case fieldCompany:
+			u.Company = value
+		case fieldCountry:
+			u.Country = value
+		case fieldJob:
+			u.Job = value
+		case fieldPhone:
+			u.Phone = value
+		case fieldBrowsers:
+			u.Browsers = u.Browsers[:0]
+			ids := f.browserIDs(idx)
+			for i := 0; i < len(ids); i += 4 {
+				u.Browsers = append(u.Browsers, f.dict.get(int(le.Uint32(ids[i:]))))
+			}
+		}
+	}
+}
+
+// FastSearchColumnar - FastSearch по файлу, который сделал ConvertColumnar
+func FastSearchColumnar(out io.Writer, path string) {
+	f, err := OpenColumnar(path)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := f.Search(AndroidAndMSIE, out); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// columnarDataset конвертирует датасет во временный файл
+func columnarDataset(tb testing.TB) string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+	path := filepath.Join(tb.TempDir(), "users.col")
+	out, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer out.Close()
+	if n, err := ConvertColumnar(file, out); err != nil || n != 1000 {
+		tb.Fatalf("converted %d users: %v", n, err)
+	}
+	return path
+}
+
+func TestColumnarSearch(t *testing.T) {
+	path := columnarDataset(t)
+
+	expected := new(bytes.Buffer)
+	FastSearch(expected)
+	got := new(bytes.Buffer)
+	FastSearchColumnar(got, path)
+	if got.String() != expected.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", got, expected)
+	}
+
+	f, err := OpenColumnar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := []Query{
+		{Where: Or(Contains("browsers", "Opera"), Prefix("company", "Fl"))},
+		{Where: And(Regex("country", "^K.*a$"), Not(Contains("email", ".com"))), Fields: []string{"country", "email"}},
+		{Where: Contains("phone", "8-9"), Fields: []string{"job", "browsers"}},
+		{},
+	}
+	for _, q := range queries {
+		expectedOut, expectedStats := runQuery(t, q)
+		out := new(bytes.Buffer)
+		stats, err := f.Search(q, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != expectedOut {
+			t.Errorf("%v: results not match\nGot:\n%v\nExpected:\n%v", q.Where, out, expectedOut)
+		}
+		if stats.Lines != expectedStats.Lines || stats.Found != expectedStats.Found ||
+			stats.UniqueBrowsers != expectedStats.UniqueBrowsers {
+			t.Errorf("%v: expected %+v, got %+v", q.Where, expectedStats, stats)
+		}
+	}
+}
+
+func TestColumnarBroken(t *testing.T) {
+	data, err := ioutil.ReadFile(columnarDataset(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := [][]byte{
+		nil,
+		[]byte("USRCOLS2"),
+		data[:len(data)/2],
+		data[:30],
+	}
+	for _, b := range broken {
+		if _, err := ParseColumnar(b); err == nil {
+			t.Errorf("file of %d bytes must not parse", len(b))
+		}
+	}
+}
+
+// колоночный поиск должен аллоцировать хотя бы в 10 раз меньше FastSearch
+func TestColumnarAllocs(t *testing.T) {
+	path := columnarDataset(t)
+	fast := testing.AllocsPerRun(5, func() { FastSearch(ioutil.Discard) })
+	columnar := testing.AllocsPerRun(5, func() { FastSearchColumnar(ioutil.Discard, path) })
+	if columnar*10 > fast {
+		t.Errorf("columnar search makes %.0f allocs, FastSearch %.0f", columnar, fast)
+	}
+}
+
+func BenchmarkColumnar(b *testing.B) {
+	path := columnarDataset(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FastSearchColumnar(ioutil.Discard, path)
+	}
+}
+
+// файл открыт заранее, как при повторной аналитике
+func BenchmarkColumnarOpened(b *testing.B) {
+	f, err := OpenColumnar(columnarDataset(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Search(AndroidAndMSIE, ioutil.Discard)
+	}
+}
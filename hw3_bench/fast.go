@@ -1,12 +1,9 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
-	"fmt"
 	"io"
 	"os"
-	"strings"
 	// "log"
 	easyjson "github.com/mailru/easyjson"
 	jlexer "github.com/mailru/easyjson/jlexer"
@@ -24,9 +21,16 @@ type user struct {
 	Name     string
 	Email    string
 	Browsers []string
+	Company  string
+	Country  string
+	Job      string
+	Phone    string
 }
 
-func easyjson9e1087fdDecodeFakeCom(in *jlexer.Lexer, out *user) {
+// easyjson9e1087fdDecodeFakeCom разбирает только поля из want, остальные пропускает.
+// С zeroCopy строки не копируются и живут, пока жив буфер in, - это для своих сканеров,
+// которые сами следят за буфером
+func easyjson9e1087fdDecodeFakeCom(in *jlexer.Lexer, out *user, want fieldSet, zeroCopy bool) {
 	isTopLevel := in.IsStart()
 	if in.IsNull() {
 		if isTopLevel {
@@ -44,12 +48,22 @@ func easyjson9e1087fdDecodeFakeCom(in *jlexer.Lexer, out *user) {
 			in.WantComma()
 			continue
 		}
-		switch key {
-		case "name":
-			out.Name = string(in.String())
-		case "email":
-			out.Email = string(in.String())
-		case "browsers":
+		switch f := fieldNames[key]; {
+		case want&f == 0:
+			in.SkipRecursive()
+		case f == fieldName:
+			out.Name = lexString(in, zeroCopy)
+		case f == fieldEmail:
+			out.Email = lexString(in, zeroCopy)
+		case f == fieldCompany:
+			out.Company = lexString(in, zeroCopy)
+		case f == fieldCountry:
+			out.Country = lexString(in, zeroCopy)
+		case f == fieldJob:
+			out.Job = lexString(in, zeroCopy)
+		case f == fieldPhone:
+			out.Phone = lexString(in, zeroCopy)
+		case f == fieldBrowsers:
 			if in.IsNull() {
 				in.Skip()
 				out.Browsers = nil
@@ -65,9 +79,7 @@ func easyjson9e1087fdDecodeFakeCom(in *jlexer.Lexer, out *user) {
 					out.Browsers = (out.Browsers)[:0]
 				}
 				for !in.IsDelim(']') {
-					var v1 string
-					v1 = string(in.String())
-					out.Browsers = append(out.Browsers, v1)
+					out.Browsers = append(out.Browsers, lexString(in, zeroCopy))
 					in.WantComma()
 				}
 				in.Delim(']')
@@ -83,10 +95,17 @@ func easyjson9e1087fdDecodeFakeCom(in *jlexer.Lexer, out *user) {
 	}
 }
 
+func lexString(in *jlexer.Lexer, zeroCopy bool) string {
+	if zeroCopy {
+		return in.UnsafeString()
+	}
+	return in.String()
+}
+
 // UnmarshalJSON supports json.Unmarshaler interface
 func (v *user) UnmarshalJSON(data []byte) error {
 	r := jlexer.Lexer{Data: data}
-	easyjson9e1087fdDecodeFakeCom(&r, v)
+	easyjson9e1087fdDecodeFakeCom(&r, v, allFields, false)
 	return r.Error()
 }
 
@@ -98,50 +117,5 @@ func FastSearch(out io.Writer) {
 	}
 	defer file.Close()
 
-	fmt.Fprintln(out, "found users:")
-
-	scanner := bufio.NewScanner(file)
-	user := user{}
-	uniqueBrowsers := make(map[string]int)
-
-	hasIE := false
-	hasAndroid := false
-
-	idx := 0
-	for scanner.Scan() {
-		hasIE = false
-		hasAndroid = false
-
-		if err := user.UnmarshalJSON(scanner.Bytes()); err != nil {
-			panic("failed to unmarshal")
-		}
-
-	LOOP:
-		for _, browser := range user.Browsers {
-			if strings.Contains(browser, "Android") {
-				hasAndroid = true
-				uniqueBrowsers[browser] = 0
-			}
-			if strings.Contains(browser, "MSIE") {
-				hasIE = true
-				uniqueBrowsers[browser] = 0
-			}
-			if hasIE && hasAndroid {
-				break LOOP
-			}
-		}
-
-		if hasIE && hasAndroid {
-			email := strings.Replace(user.Email, "@", " [at] ", 100)
-			fmt.Fprintf(out, "[%d] %s <%s>\n", idx, user.Name, email)
-		}
-
-		idx++
-	}
-
-	if err := scanner.Err(); err != nil {
-		panic("error reading file")
-	}
-
-	fmt.Fprintln(out, "\nTotal unique browsers", len(uniqueBrowsers))
+	SearchQuery(file, AndroidAndMSIE, out)
 }
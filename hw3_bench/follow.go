@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	jlexer "github.com/mailru/easyjson/jlexer"
+)
+
+const (
+	defaultFollowPoll  = 200 * time.Millisecond
+	defaultFollowEvery = 5 * time.Second
+)
+
+// Follower ищет по файлу как tail -f: после конца файла ждет дописанные строки.
+// Уникальные браузеры и номера строк копятся через все порции, обрезанный файл читается
+// с начала, а после ротации (по пути лежит другой файл) старый дочитывается и открывается новый
+type Follower struct {
+	Path  string
+	Query Query
+	// как часто проверять файл, по умолчанию defaultFollowPoll
+	Poll time.Duration
+	// как часто печатать итоги в Totals, по умолчанию defaultFollowEvery
+	Every time.Duration
+	// найденные пользователи в формате FastSearch
+	Out io.Writer
+	// итоги, nil - не печатать
+	Totals io.Writer
+	// пропускать битые строки, как SearchOptions.Lenient
+	Lenient bool
+
+	query   *compiledQuery
+	state   *queryState
+	stats   Stats
+	file    *os.File
+	offset  int64
+	pending []byte
+	chunk   []byte
+	u       *user
+	line    []byte
+
+	mu       sync.Mutex
+	snapshot Stats
+}
+
+// Stats - итоги на момент последней прочитанной порции
+func (f *Follower) Stats() Stats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.snapshot
+}
+
+// Run читает файл, пока не отменят ctx, и возвращает ctx.Err()
+func (f *Follower) Run(ctx context.Context) error {
+	query, err := compileQuery(f.Query)
+	if err != nil {
+		return err
+	}
+	f.query, f.state = query, newQueryState(query)
+	f.u, f.chunk = &user{}, make([]byte, 64*1024)
+	poll, every := f.Poll, f.Every
+	if poll <= 0 {
+		poll = defaultFollowPoll
+	}
+	if every <= 0 {
+		every = defaultFollowEvery
+	}
+
+	if f.file, err = os.Open(f.Path); err != nil {
+		return err
+	}
+	// после ротации f.file уже другой
+	defer func() {
+		f.file.Close()
+	}()
+
+	w := bufio.NewWriter(f.Out)
+	defer w.Flush()
+	pollTicker, reportTicker := time.NewTicker(poll), time.NewTicker(every)
+	defer pollTicker.Stop()
+	defer reportTicker.Stop()
+
+	for {
+		if err := f.readAvailable(w); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			f.report()
+			return ctx.Err()
+		case <-reportTicker.C:
+			f.report()
+		case <-pollTicker.C:
+		}
+	}
+}
+
+func (f *Follower) report() {
+	if f.Totals == nil {
+		return
+	}
+	s := f.Stats()
+	fmt.Fprintf(f.Totals, "lines %d, found %d, unique browsers %d\n", s.Lines, s.Found, s.UniqueBrowsers)
+}
+
+// readAvailable дочитывает файл до текущего конца и проверяет ротацию и обрезку
+func (f *Follower) readAvailable(w *bufio.Writer) error {
+	for {
+		if err := f.readToEOF(w); err != nil {
+			return err
+		}
+
+		info, err := f.file.Stat()
+		if err != nil {
+			return err
+		}
+		current, err := os.Stat(f.Path)
+		if err == nil && !os.SameFile(info, current) {
+			// старый файл дочитан, хвост без перевода строки - последняя строка
+			if len(f.pending) > 0 {
+				if err := f.processLine(w, f.pending); err != nil {
+					return err
+				}
+			}
+			next, err := os.Open(f.Path)
+			if err != nil {
+				return err
+			}
+			f.file.Close()
+			f.file, f.offset, f.pending = next, 0, f.pending[:0]
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if info.Size() < f.offset {
+			if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			f.offset, f.pending = 0, f.pending[:0]
+			continue
+		}
+		break
+	}
+
+	f.stats.UniqueBrowsers = len(f.state.uniqueBrowsers)
+	f.mu.Lock()
+	f.snapshot = f.stats
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *Follower) readToEOF(w *bufio.Writer) error {
+	for {
+		n, err := f.file.Read(f.chunk)
+		if n > 0 {
+			f.offset += int64(n)
+			f.pending = append(f.pending, f.chunk[:n]...)
+			start := 0
+			for {
+				end := bytes.IndexByte(f.pending[start:], '\n')
+				if end < 0 {
+					break
+				}
+				if err := f.processLine(w, f.pending[start:start+end]); err != nil {
+					return err
+				}
+				start += end + 1
+			}
+			f.pending = append(f.pending[:0], f.pending[start:]...)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &ReadError{Line: f.stats.Lines + 1, Err: err}
+		}
+	}
+}
+
+func (f *Follower) processLine(w *bufio.Writer, data []byte) error {
+	idx := f.stats.Lines
+	f.stats.Lines++
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	*f.u = user{Browsers: f.u.Browsers[:0]}
+	lexer := jlexer.Lexer{Data: data}
+	easyjson9e1087fdDecodeFakeCom(&lexer, f.u, f.query.want, true)
+	if err := lexer.Error(); err != nil {
+		lineErr := &LineError{Line: idx + 1, Err: err}
+		if !f.Lenient {
+			return lineErr
+		}
+		f.stats.Malformed = append(f.stats.Malformed, lineErr)
+		return nil
+	}
+	if f.state.match(f.u) {
+		f.stats.Found++
+		f.line = f.query.appendLine(f.line[:0], idx, f.u)
+		w.Write(f.line)
+	}
+	return nil
+}
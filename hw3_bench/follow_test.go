@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer - буфер, в который пишет Follower, пока тест его читает
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func waitLines(t *testing.T, f *Follower, lines int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for f.Stats().Lines < lines {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d lines, got %+v", lines, f.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func appendFile(t *testing.T, path string, data []byte) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFollower(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	lines[len(lines)-1] += "\n"
+
+	path := filepath.Join(t.TempDir(), "users.txt")
+	appendFile(t, path, nil)
+
+	out, totals := &syncBuffer{}, &syncBuffer{}
+	f := &Follower{Path: path, Query: AndroidAndMSIE, Poll: 5 * time.Millisecond, Every: 20 * time.Millisecond,
+		Out: out, Totals: totals}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- f.Run(ctx)
+	}()
+
+	// дописываем порциями, иногда обрывая строку посередине
+	go func() {
+		for i := 0; i < len(lines); i += 50 {
+			batch := strings.Join(lines[i:i+50], "")
+			cut := len(batch) - 10
+			appendFile(t, path, []byte(batch[:cut]))
+			time.Sleep(time.Millisecond)
+			appendFile(t, path, []byte(batch[cut:]))
+		}
+	}()
+	waitLines(t, f, len(lines))
+
+	expected := new(bytes.Buffer)
+	stats := SearchQuery(bytes.NewReader(data), AndroidAndMSIE, expected)
+	body := strings.TrimSuffix(strings.TrimPrefix(expected.String(), "found users:\n"), "\nTotal unique browsers 114\n")
+	if out.String() != body {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out, body)
+	}
+	if got := f.Stats(); got.Found != stats.Found || got.UniqueBrowsers != stats.UniqueBrowsers {
+		t.Errorf("expected %+v, got %+v", stats, got)
+	}
+
+	// обрезка: файл читается с начала, номера строк продолжаются
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, []byte(strings.Join(lines[:3], "")))
+	waitLines(t, f, len(lines)+3)
+
+	// ротация: дописанное в старый файл не теряется, новый читается с начала
+	appendFile(t, path, []byte(lines[3]))
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, []byte(strings.Join(lines[4:6], "")))
+	waitLines(t, f, len(lines)+6)
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if got := f.Stats(); got.Lines != len(lines)+6 || got.UniqueBrowsers != stats.UniqueBrowsers {
+		t.Errorf("unexpected final stats %+v", got)
+	}
+	if !strings.Contains(totals.String(), "lines 1006, found") {
+		t.Errorf("no final totals in:\n%s", totals)
+	}
+}
+
+func TestFollowerMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	appendFile(t, path, []byte("{\"browsers\":[\"MSIE\", \"Android\"]}\nnot json\n"))
+
+	f := &Follower{Path: path, Poll: time.Millisecond, Out: ioutil.Discard}
+	err := f.Run(context.Background())
+	if lineErr, ok := err.(*LineError); !ok || lineErr.Line != 2 {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+
+	f = &Follower{Path: path, Poll: time.Millisecond, Out: ioutil.Discard, Lenient: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for f.Stats().Lines < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	f.Run(ctx)
+	if got := f.Stats(); got.Found != 1 || len(got.Malformed) != 1 {
+		t.Errorf("unexpected lenient stats %+v", got)
+	}
+}
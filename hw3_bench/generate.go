@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	jlexer "github.com/mailru/easyjson/jlexer"
+	jwriter "github.com/mailru/easyjson/jwriter"
+)
+
+// BrowserWeight - браузер и его относительная частота в генерируемых данных
+type BrowserWeight struct {
+	UA     string  `json:"ua"`
+	Weight float64 `json:"weight"`
+}
+
+// GenConfig - параметры генератора. Одинаковые параметры дают байт в байт одинаковый файл
+type GenConfig struct {
+	Seed            int64
+	Users           int
+	BrowsersPerUser int
+	// пусто - defaultBrowserWeights
+	Browsers []BrowserWeight
+}
+
+// частоты примерно как в data/users.txt: Android и MSIE встречаются часто,
+// но у одного пользователя вместе - редко
+var defaultBrowserWeights = []BrowserWeight{
+	{"Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2228.0 Safari/537.36", 12},
+	{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/52.0.2743.116 Safari/537.36", 8},
+	{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_10_5) AppleWebKit/602.1.50 (KHTML, like Gecko) Version/10.0 Safari/602.1.50", 8},
+	{"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:52.0) Gecko/20100101 Firefox/52.0", 10},
+	{"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:49.0) Gecko/20100101 Firefox/49.0", 6},
+	{"Mozilla/5.0 (iPhone; CPU iPhone OS 9_2 like Mac OS X) AppleWebKit/601.1.46 (KHTML, like Gecko) Version/9.0 Mobile/13C75 Safari/601.1", 6},
+	{"Mozilla/5.0 (iPad; CPU OS 9_3_2 like Mac OS X) AppleWebKit/601.1.46 (KHTML, like Gecko) Version/9.0 Mobile/13F69 Safari/601.1", 3},
+	{"Opera/9.80 (X11; Linux i686) Presto/2.12.388 Version/12.16", 3},
+	{"Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko", 3},
+	{"Mozilla/5.0 (compatible; MSIE 10.0; Windows NT 6.1; WOW64; Trident/6.0)", 2},
+	{"Mozilla/4.0 (compatible; MSIE 8.0; Windows NT 6.0; Trident/4.0)", 2},
+	{"Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 6.0)", 1.5},
+	{"Mozilla/4.0 (compatible; MSIE 6.0; Windows CE; IEMobile 7.11) XV6800", 1},
+	{"Mozilla/5.0 (Linux; Android 6.0; LG-D850 Build/MRA58K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/53.0.2785.97 Mobile Safari/537.36", 4},
+	{"Mozilla/5.0 (Linux; U; Android 2.2; en-us; Nexus One Build/FRF91) AppleWebKit/533.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/533.1", 3},
+	{"Mozilla/5.0 (Linux; U; Android 4.0.3; ko-kr; LG-L160L Build/IML74K) AppleWebKit/534.30 (KHTML, like Gecko) Version/4.0 Mobile Safari/534.30", 2},
+	{"Mozilla/5.0 (Android; Linux armv7l; rv:10.0.1) Gecko/20100101 Firefox/10.0.1 Fennec/10.0.1", 1},
+	{"Mozilla/5.0 (Windows Phone 8.1; ARM; Trident/7.0; Touch; rv:11.0; IEMobile/11.0; NOKIA; Lumia 630) like Gecko", 1},
+	{"Googlebot/2.1 ( http://www.googlebot.com/bot.html)", 2},
+	{"Mozilla/5.0 (compatible; bingbot/2.0  http://www.bing.com/bingbot.htm)", 1},
+	{"Wget/1.12 (freebsd8.1)", 0.5},
+	{"LG-LX550 AU-MIC-LX550/2.0 MMP/2.0 Profile/MIDP-2.0 Configuration/CLDC-1.1", 0.5},
+}
+
+var (
+	genFirstNames = []string{"Sharon", "Jonathan", "Maria", "Ivan", "Helen", "Peter", "Anna", "Carlos",
+		"Julia", "Dmitry", "Laura", "Kevin", "Olga", "Steven", "Diana", "Frank"}
+	genLastNames = []string{"Crawford", "Morris", "Garcia", "Petrov", "Hughes", "Kim", "Fuller", "Lopez",
+		"Reed", "Smirnova", "Walker", "Young", "Ford", "Nelson", "Price", "Bell"}
+	genCompanies = []string{"Flashpoint", "Jatri", "Dabtype", "Thoughtbeat", "Youfeed", "Muxo", "Skinix",
+		"Quatz", "Oyoloo", "Zoomzone", "Realcube", "Twitterbeat", "Voonyx", "Blogtag"}
+	genCountries = []string{"Dominican Republic", "Kenya", "Russia", "Brazil", "Canada", "France", "India",
+		"Japan", "Mexico", "Norway", "Peru", "Spain", "Thailand", "Uganda"}
+	genJobs = []string{"Programmer Analyst #{N}", "Web Developer #{N}", "Internal Auditor",
+		"Office Assistant #{N}", "Automation Specialist #{N}", "Project Manager", "Data Coordinator",
+		"Quality Engineer", "Staff Accountant #{N}", "Research Nurse"}
+	genDomains = []string{"com", "edu", "org", "net", "info", "biz", "gov", "mil"}
+)
+
+// BrowserWeightsFrom считает частоты браузеров по NDJSON пользователей, например data/users.txt
+func BrowserWeightsFrom(r io.Reader) ([]BrowserWeight, error) {
+	counts := counter{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		u := &user{}
+		lexer := jlexer.Lexer{Data: scanner.Bytes()}
+		easyjson9e1087fdDecodeFakeCom(&lexer, u, fieldBrowsers, true)
+		if err := lexer.Error(); err != nil {
+			return nil, &LineError{Line: line, Err: err}
+		}
+		for _, browser := range u.Browsers {
+			counts[strings.Clone(browser)]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	weights := []BrowserWeight{}
+	for _, c := range counts.top(0) {
+		weights = append(weights, BrowserWeight{UA: c.Name, Weight: float64(c.Users)})
+	}
+	return weights, nil
+}
+
+// ReadBrowserWeights читает JSON-массив [{"ua": "...", "weight": 1.5}, ...]
+func ReadBrowserWeights(r io.Reader) ([]BrowserWeight, error) {
+	weights := []BrowserWeight{}
+	if err := json.NewDecoder(r).Decode(&weights); err != nil {
+		return nil, err
+	}
+	return weights, nil
+}
+
+// browserSampler выбирает браузеры по весам
+type browserSampler struct {
+	browsers []string
+	// накопленные веса
+	bounds []float64
+}
+
+func newBrowserSampler(weights []BrowserWeight) (*browserSampler, error) {
+	s := &browserSampler{}
+	total := 0.0
+	for _, w := range weights {
+		if w.Weight < 0 || w.UA == "" {
+			return nil, fmt.Errorf("bad browser weight %+v", w)
+		}
+		if w.Weight == 0 {
+			continue
+		}
+		total += w.Weight
+		s.browsers = append(s.browsers, w.UA)
+		s.bounds = append(s.bounds, total)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("no browsers to generate")
+	}
+	return s, nil
+}
+
+func (s *browserSampler) pick(rnd *rand.Rand) string {
+	x := rnd.Float64() * s.bounds[len(s.bounds)-1]
+	return s.browsers[sort.SearchFloat64s(s.bounds, x)]
+}
+
+// GenerateUsers пишет cfg.Users пользователей в data в формате data/users.txt, а в expected -
+// то, что для этих данных должен вывести FastSearch. Возвращает итоги этого поиска
+func GenerateUsers(cfg GenConfig, data, expected io.Writer) (Stats, error) {
+	stats := Stats{}
+	if cfg.BrowsersPerUser <= 0 {
+		cfg.BrowsersPerUser = 4
+	}
+	weights := cfg.Browsers
+	if len(weights) == 0 {
+		weights = defaultBrowserWeights
+	}
+	sampler, err := newBrowserSampler(weights)
+	if err != nil {
+		return stats, err
+	}
+
+	rnd := rand.New(rand.NewSource(cfg.Seed))
+	out := bufio.NewWriterSize(data, 64*1024)
+	answer := bufio.NewWriter(expected)
+	answer.WriteString("found users:\n")
+
+	browsers := make([]string, cfg.BrowsersPerUser)
+	unique := make(map[string]struct{})
+	jw := &jwriter.Writer{NoEscapeHTML: true}
+	line := []byte{}
+	for idx := 0; idx < cfg.Users; idx++ {
+		isAndroid, isMSIE := false, false
+		for i := range browsers {
+			browsers[i] = sampler.pick(rnd)
+			if strings.Contains(browsers[i], "Android") {
+				isAndroid = true
+				unique[browsers[i]] = struct{}{}
+			}
+			if strings.Contains(browsers[i], "MSIE") {
+				isMSIE = true
+				unique[browsers[i]] = struct{}{}
+			}
+		}
+		u := user{
+			Browsers: browsers,
+			Company:  genCompanies[rnd.Intn(len(genCompanies))],
+			Country:  genCountries[rnd.Intn(len(genCountries))],
+			Email: genFirstNames[rnd.Intn(len(genFirstNames))] + genLastNames[rnd.Intn(len(genLastNames))] +
+				"@" + genCompanies[rnd.Intn(len(genCompanies))] + "." + genDomains[rnd.Intn(len(genDomains))],
+			Job:   genJobs[rnd.Intn(len(genJobs))],
+			Name:  genFirstNames[rnd.Intn(len(genFirstNames))] + " " + genLastNames[rnd.Intn(len(genLastNames))],
+			Phone: genPhone(rnd),
+		}
+
+		if idx > 0 {
+			jw.RawByte('\n')
+		}
+		writeUserJSON(jw, &u)
+		if jw.Size() > 32*1024 {
+			if _, err := jw.DumpTo(out); err != nil {
+				return stats, err
+			}
+		}
+
+		if isAndroid && isMSIE {
+			stats.Found++
+			line = append(line[:0], '[')
+			line = strconv.AppendInt(line, int64(idx), 10)
+			line = append(line, "] "...)
+			line = append(line, u.Name...)
+			line = append(line, " <"...)
+			line = append(line, strings.Replace(u.Email, "@", " [at] ", 1)...)
+			line = append(line, ">\n"...)
+			answer.Write(line)
+		}
+	}
+	if _, err := jw.DumpTo(out); err != nil {
+		return stats, err
+	}
+	if err := out.Flush(); err != nil {
+		return stats, err
+	}
+
+	stats.Lines = cfg.Users
+	stats.UniqueBrowsers = len(unique)
+	fmt.Fprintln(answer, "\nTotal unique browsers", stats.UniqueBrowsers)
+	return stats, answer.Flush()
+}
+
+// writeUserJSON пишет пользователя с полями в том же порядке, что и в data/users.txt
+func writeUserJSON(w *jwriter.Writer, u *user) {
+	w.RawString(`{"browsers":[`)
+	for i, browser := range u.Browsers {
+		if i > 0 {
+			w.RawByte(',')
+		}
+		w.String(browser)
+	}
+	w.RawString(`],"company":`)
+	w.String(u.Company)
+	w.RawString(`,"country":`)
+	w.String(u.Country)
+	w.RawString(`,"email":`)
+	w.String(u.Email)
+	w.RawString(`,"job":`)
+	w.String(u.Job)
+	w.RawString(`,"name":`)
+	w.String(u.Name)
+	w.RawString(`,"phone":`)
+	w.String(u.Phone)
+	w.RawByte('}')
+}
+
+// genPhone - городской номер вида 176-88-49 или мобильный 8-912-514-24-86
+func genPhone(rnd *rand.Rand) string {
+	if rnd.Intn(4) == 0 {
+		return fmt.Sprintf("8-9%02d-%03d-%02d-%02d", rnd.Intn(100), rnd.Intn(1000), rnd.Intn(100), rnd.Intn(100))
+	}
+	return fmt.Sprintf("%03d-%02d-%02d", 100+rnd.Intn(900), rnd.Intn(100), rnd.Intn(100))
+}
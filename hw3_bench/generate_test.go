@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateUsers(t *testing.T) {
+	cfg := GenConfig{Seed: 42, Users: 5000}
+	data, expected := new(bytes.Buffer), new(bytes.Buffer)
+	stats, err := GenerateUsers(cfg, data, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Found == 0 || stats.Found == cfg.Users {
+		t.Fatalf("unexpected generated stats %+v", stats)
+	}
+
+	again := new(bytes.Buffer)
+	GenerateUsers(cfg, again, ioutil.Discard)
+	if !bytes.Equal(data.Bytes(), again.Bytes()) {
+		t.Error("same seed must give the same data")
+	}
+	other := new(bytes.Buffer)
+	GenerateUsers(GenConfig{Seed: 43, Users: 5000}, other, ioutil.Discard)
+	if bytes.Equal(data.Bytes(), other.Bytes()) {
+		t.Error("different seeds gave the same data")
+	}
+
+	out := new(bytes.Buffer)
+	got := SearchQuery(bytes.NewReader(data.Bytes()), AndroidAndMSIE, out)
+	if out.String() != expected.String() {
+		t.Errorf("search result differs from expected answer")
+	}
+	if got.Lines != cfg.Users || got.Found != stats.Found || got.UniqueBrowsers != stats.UniqueBrowsers {
+		t.Errorf("expected %+v, got %+v", stats, got)
+	}
+}
+
+func TestGenerateWeights(t *testing.T) {
+	weights, err := ReadBrowserWeights(strings.NewReader(`[{"ua": "Android MSIE", "weight": 1}, {"ua": "never", "weight": 0}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := new(bytes.Buffer)
+	stats, err := GenerateUsers(GenConfig{Users: 10, BrowsersPerUser: 2, Browsers: weights}, data, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Found != 10 || stats.UniqueBrowsers != 1 || strings.Contains(data.String(), "never") {
+		t.Errorf("unexpected stats %+v for single browser", stats)
+	}
+
+	if _, err := GenerateUsers(GenConfig{Users: 1, Browsers: []BrowserWeight{{"x", -1}}}, data, ioutil.Discard); err == nil {
+		t.Error("negative weight must fail")
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	sample, err := BrowserWeightsFrom(file)
+	if err != nil || len(sample) != 670 || sample[0].Weight < sample[len(sample)-1].Weight {
+		t.Errorf("unexpected weights from sample: %d, %v", len(sample), err)
+	}
+}
+
+// go test -bench Generated -benchmem - FastSearch на 100 тысячах строк
+func BenchmarkSearchGenerated(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "users.txt")
+	file, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := GenerateUsers(GenConfig{Seed: 1, Users: 100000}, file, ioutil.Discard); err != nil {
+		b.Fatal(err)
+	}
+	file.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		file, _ := os.Open(path)
+		SearchQuery(file, AndroidAndMSIE, ioutil.Discard)
+		file.Close()
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	jlexer "github.com/mailru/easyjson/jlexer"
+)
+
+// BrowserIndex - обратный индекс по браузерам: словарь разных браузеров, токены браузеров
+// и списки пользователей (номеров строк) для каждого браузера
+type BrowserIndex struct {
+	ModTime int64
+	Size    int64
+	Hash    string
+
+	Browsers []string
+	// токен -> номера браузеров в словаре
+	Tokens map[string][]int
+	// номер браузера -> номера пользователей по возрастанию
+	Postings [][]int
+	// номер пользователя -> смещение его строки в исходном файле
+	Offsets []int64
+
+	// индекс пришлось построить заново при открытии
+	rebuilt bool
+}
+
+// OpenBrowserIndex читает индекс source из indexPath. Если у source поменялись время
+// изменения или размер, индекс сверяет хеш содержимого и перестраивается, если файл
+// действительно другой
+func OpenBrowserIndex(source, indexPath string) (*BrowserIndex, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	ix, err := loadBrowserIndex(indexPath)
+	if err == nil && ix.ModTime == info.ModTime().UnixNano() && ix.Size == info.Size() {
+		return ix, nil
+	}
+
+	hash, err := fileHash(source)
+	if err != nil {
+		return nil, err
+	}
+	if ix == nil || ix.Hash != hash {
+		if ix, err = BuildBrowserIndex(source); err != nil {
+			return nil, err
+		}
+		ix.rebuilt = true
+	}
+	ix.ModTime, ix.Size, ix.Hash = info.ModTime().UnixNano(), info.Size(), hash
+	return ix, ix.Save(indexPath)
+}
+
+func loadBrowserIndex(path string) (*BrowserIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	ix := &BrowserIndex{}
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(ix); err != nil {
+		return nil, fmt.Errorf("broken index %s: %s", path, err)
+	}
+	return ix, nil
+}
+
+func fileHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (ix *BrowserIndex) Save(path string) error {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(ix); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// BuildBrowserIndex разбирает source целиком и строит индекс
+func BuildBrowserIndex(source string) (*BrowserIndex, error) {
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ix := &BrowserIndex{Tokens: make(map[string][]int)}
+	ids := make(map[string]int)
+	reader := bufio.NewReader(file)
+	u := &user{}
+	offset := int64(0)
+
+	for idx := 0; ; idx++ {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		ix.Offsets = append(ix.Offsets, offset)
+		offset += int64(len(line))
+
+		*u = user{Browsers: u.Browsers[:0]}
+		lexer := jlexer.Lexer{Data: line}
+		easyjson9e1087fdDecodeFakeCom(&lexer, u, fieldBrowsers, true)
+		if err := lexer.Error(); err != nil {
+			return nil, fmt.Errorf("line %d: %s", idx+1, err)
+		}
+
+		for _, browser := range u.Browsers {
+			id, ok := ids[browser]
+			if !ok {
+				id = len(ix.Browsers)
+				browser = strings.Clone(browser)
+				ids[browser] = id
+				ix.Browsers = append(ix.Browsers, browser)
+				ix.Postings = append(ix.Postings, nil)
+				for _, token := range browserTokens(browser) {
+					ix.Tokens[token] = appendUnique(ix.Tokens[token], id)
+				}
+			}
+			ix.Postings[id] = appendUnique(ix.Postings[id], idx)
+		}
+	}
+	ix.Size = offset
+	return ix, nil
+}
+
+// browserTokens режет строку на слова из букв и цифр
+func browserTokens(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// appendUnique добавляет v в возрастающий список, если его там еще нет в конце
+func appendUnique(list []int, v int) []int {
+	if len(list) > 0 && list[len(list)-1] == v {
+		return list
+	}
+	return append(list, v)
+}
+
+// matchBrowsers возвращает номера браузеров, содержащих подстроку. Кандидаты берутся
+// из токенов: первое слово подстроки может быть концом токена, последнее - началом,
+// средние совпадают с токенами целиком. Кандидаты потом проверяются по строке
+func (ix *BrowserIndex) matchBrowsers(substr string) []int {
+	words := browserTokens(substr)
+	var candidates map[int]bool
+	for i, word := range words {
+		found := make(map[int]bool)
+		for token, browsers := range ix.Tokens {
+			ok := false
+			switch {
+			case len(words) == 1:
+				ok = strings.Contains(token, word)
+			case i == 0:
+				ok = strings.HasSuffix(token, word)
+			case i == len(words)-1:
+				ok = strings.HasPrefix(token, word)
+			default:
+				ok = token == word
+			}
+			if !ok {
+				continue
+			}
+			for _, id := range browsers {
+				if candidates == nil || candidates[id] {
+					found[id] = true
+				}
+			}
+		}
+		candidates = found
+	}
+
+	result := []int{}
+	for id, browser := range ix.Browsers {
+		if (candidates == nil || candidates[id]) && strings.Contains(browser, substr) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// usersWith объединяет списки пользователей браузеров
+func (ix *BrowserIndex) usersWith(browsers []int) []int {
+	seen := make(map[int]bool)
+	result := []int{}
+	for _, id := range browsers {
+		for _, idx := range ix.Postings[id] {
+			if !seen[idx] {
+				seen[idx] = true
+				result = append(result, idx)
+			}
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+func intersect(a, b []int) []int {
+	result := []int{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// SearchBrowsers находит пользователей, у которых для каждой подстроки есть подходящий
+// браузер, и выводит их как FastSearch. Строки найденных пользователей читаются из source
+func (ix *BrowserIndex) SearchBrowsers(source io.ReaderAt, out io.Writer, substrings ...string) Stats {
+	stats := Stats{Lines: len(ix.Offsets)}
+	unique := make(map[int]bool)
+	var found []int
+	for i, substr := range substrings {
+		browsers := ix.matchBrowsers(substr)
+		for _, id := range browsers {
+			unique[id] = true
+		}
+		users := ix.usersWith(browsers)
+		if i == 0 {
+			found = users
+		} else {
+			found = intersect(found, users)
+		}
+	}
+
+	query, _ := compileQuery(Query{Fields: []string{"name", "email"}})
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	w.WriteString("found users:\n")
+
+	u := &user{}
+	buf := []byte{}
+	line := []byte{}
+	for _, idx := range found {
+		end := ix.Size
+		if idx+1 < len(ix.Offsets) {
+			end = ix.Offsets[idx+1]
+		}
+		if n := int(end - ix.Offsets[idx]); cap(buf) < n {
+			buf = make([]byte, n)
+		} else {
+			buf = buf[:n]
+		}
+		if _, err := source.ReadAt(buf, ix.Offsets[idx]); err != nil && err != io.EOF {
+			panic(err)
+		}
+
+		*u = user{}
+		lexer := jlexer.Lexer{Data: buf}
+		easyjson9e1087fdDecodeFakeCom(&lexer, u, query.want, true)
+		if err := lexer.Error(); err != nil {
+			panic("failed to unmarshal")
+		}
+		line = query.appendLine(line[:0], idx, u)
+		w.Write(line)
+		stats.Found++
+	}
+
+	stats.UniqueBrowsers = len(unique)
+	fmt.Fprintln(w, "\nTotal unique browsers", stats.UniqueBrowsers)
+	return stats
+}
+
+// FastSearchIndexed - FastSearch через индекс, который лежит в indexPath и
+// перестраивается при изменении файла
+func FastSearchIndexed(out io.Writer, indexPath string) {
+	ix, err := OpenBrowserIndex(filePath, indexPath)
+	if err != nil {
+		panic(err)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	ix.SearchBrowsers(file, out, "Android", "MSIE")
+}
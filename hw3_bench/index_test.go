@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBrowserIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	indexPath := filepath.Join(dir, "users.idx")
+
+	fastOut := new(bytes.Buffer)
+	FastSearch(fastOut)
+	indexedOut := new(bytes.Buffer)
+	FastSearchIndexed(indexedOut, indexPath)
+	if indexedOut.String() != fastOut.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", indexedOut.String(), fastOut.String())
+	}
+
+	ix, err := OpenBrowserIndex(filePath, indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.rebuilt {
+		t.Errorf("index of unchanged file must not be rebuilt")
+	}
+
+	for _, substr := range []string{"SIE 1", "Linux x86", "/", "Windows NT 6.1; WOW64"} {
+		expected := 0
+		for _, browser := range ix.Browsers {
+			if bytes.Contains([]byte(browser), []byte(substr)) {
+				expected++
+			}
+		}
+		if got := len(ix.matchBrowsers(substr)); got != expected || expected == 0 {
+			t.Errorf("%q: expected %d browsers, got %d", substr, expected, got)
+		}
+	}
+}
+
+func TestBrowserIndexRebuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "users.txt")
+	indexPath := filepath.Join(dir, "users.idx")
+
+	line := `{"browsers":["Android 4.0 MSIE 9.0"],"email":"a@b.c","name":"First"}` + "\n"
+	if err := ioutil.WriteFile(source, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ix, err := OpenBrowserIndex(source, indexPath)
+	if err != nil || !ix.rebuilt || len(ix.Offsets) != 1 {
+		t.Fatalf("expected fresh index, got %v %+v", err, ix)
+	}
+
+	// время изменилось, а содержимое нет - индекс не перестраивается
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(source, later, later)
+	if ix, err = OpenBrowserIndex(source, indexPath); err != nil || ix.rebuilt {
+		t.Errorf("touched file must reuse index: %v", err)
+	}
+
+	if err := ioutil.WriteFile(source, []byte(line+line), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ix, err = OpenBrowserIndex(source, indexPath); err != nil || !ix.rebuilt || len(ix.Offsets) != 2 {
+		t.Fatalf("changed file must rebuild index: %v", err)
+	}
+
+	file, _ := os.Open(source)
+	defer file.Close()
+	out := new(bytes.Buffer)
+	stats := ix.SearchBrowsers(file, out, "Android", "MSIE")
+	if stats.Found != 2 || stats.UniqueBrowsers != 1 {
+		t.Errorf("unexpected stats %+v\n%s", stats, out)
+	}
+}
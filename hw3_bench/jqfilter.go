@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	jlexer "github.com/mailru/easyjson/jlexer"
+)
+
+// Filter - условие в духе jq над строкой NDJSON без схемы, например
+//
+//	.browsers[] | contains("MSIE") and .country == "Kenya"
+//
+// Пути: .a.b, .a[] (все элементы массива или значения объекта), .a[2]. После пути
+// может идти | contains("s"), | startswith("s"), | endswith("s"), | test("regexp"),
+// | not или сравнение ==, !=, <, <=, >, >= с литералом. Путь без условия проверяет, что
+// значение есть и не null/false. Условия объединяются and, or, not и скобками,
+// select(...) допускается и ничего не меняет. В отличие от jq, | связывает сильнее and/or.
+// Если путь дает несколько значений, условие выполняется, когда подошло хотя бы одно.
+// Отсутствующее значение считается null.
+//
+// Строка разбирается за один проход jlexer, поддеревья, которых нет в путях, пропускаются.
+// Filter хранит состояние текущей строки, поэтому из нескольких горутин нужны копии через ParseFilter
+type Filter struct {
+	src    string
+	root   *filterExpr
+	leaves []*filterLeaf
+	paths  *pathNode
+
+	// по листьям для текущей строки
+	results []bool
+	seen    []bool
+}
+
+type filterExpr struct {
+	op   string
+	args []*filterExpr
+	leaf int
+}
+
+type filterLeaf struct {
+	path string
+	// "" - проверка на существование, иначе функция или оператор сравнения
+	fn  string
+	lit jlexer.TokenKind
+	str string
+	num float64
+	b   bool
+	re  *regexp.Regexp
+}
+
+// pathNode - узел дерева всех путей фильтра
+type pathNode struct {
+	fields  map[string]*pathNode
+	index   map[int]*pathNode
+	iterate *pathNode
+	// листья, которые проверяют значение в этом узле
+	leaves []int
+}
+
+func (n *pathNode) empty() bool {
+	return len(n.fields) == 0 && len(n.index) == 0 && n.iterate == nil
+}
+
+// FilterError - ошибка разбора выражения фильтра в позиции Pos
+type FilterError struct {
+	Filter string
+	Pos    int
+	Msg    string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("filter %q at %d: %s", e.Filter, e.Pos, e.Msg)
+}
+
+func ParseFilter(src string) (*Filter, error) {
+	p := &filterParser{src: src, f: &Filter{src: src, paths: &pathNode{}}}
+	root, err := p.parseOr()
+	if err == nil {
+		p.skipSpaces()
+		if p.pos < len(src) {
+			err = p.errorf("unexpected %q", src[p.pos:])
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.f.root = root
+	p.f.results = make([]bool, len(p.f.leaves))
+	p.f.seen = make([]bool, len(p.f.leaves))
+	return p.f, nil
+}
+
+func (f *Filter) String() string {
+	return f.src
+}
+
+type filterParser struct {
+	src string
+	pos int
+	f   *Filter
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return &FilterError{Filter: p.src, Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) skipSpaces() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// consume пропускает s, если вход с него начинается
+func (p *filterParser) consume(s string) bool {
+	p.skipSpaces()
+	if !strings.HasPrefix(p.src[p.pos:], s) {
+		return false
+	}
+	// ключевые слова не должны быть началом имени
+	if isIdentByte(s[len(s)-1]) && p.pos+len(s) < len(p.src) && isIdentByte(p.src[p.pos+len(s)]) {
+		return false
+	}
+	p.pos += len(s)
+	return true
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (p *filterParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) && isIdentByte(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *filterParser) parseOr() (*filterExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.consume("or") {
+		var right *filterExpr
+		if right, err = p.parseAnd(); err == nil {
+			left = &filterExpr{op: "or", args: []*filterExpr{left, right}}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) parseAnd() (*filterExpr, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.consume("and") {
+		var right *filterExpr
+		if right, err = p.parseUnary(); err == nil {
+			left = &filterExpr{op: "and", args: []*filterExpr{left, right}}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) parseUnary() (*filterExpr, error) {
+	switch {
+	case p.consume("not"):
+		arg, err := p.parseUnary()
+		return &filterExpr{op: "not", args: []*filterExpr{arg}}, err
+	case p.consume("select"):
+		if !p.consume("(") {
+			return nil, p.errorf("expected ( after select")
+		}
+		fallthrough
+	case p.consume("("):
+		e, err := p.parseOr()
+		if err == nil && !p.consume(")") {
+			err = p.errorf("expected )")
+		}
+		return e, err
+	}
+	return p.parseLeaf()
+}
+
+var filterFuncs = map[string]bool{"contains": true, "startswith": true, "endswith": true, "test": true}
+
+// parseLeaf разбирает путь и условие на него
+func (p *filterParser) parseLeaf() (*filterExpr, error) {
+	p.skipSpaces()
+	start := p.pos
+	node, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	leaf := &filterLeaf{path: strings.TrimSpace(p.src[start:p.pos])}
+	negate := false
+
+	switch {
+	case p.consume("|"):
+		p.skipSpaces()
+		name := p.ident()
+		if name == "not" {
+			negate = true
+			break
+		}
+		if !filterFuncs[name] {
+			return nil, p.errorf("unknown function %q", name)
+		}
+		if !p.consume("(") {
+			return nil, p.errorf("expected ( after %s", name)
+		}
+		if err := p.parseLiteral(leaf); err != nil {
+			return nil, err
+		}
+		if leaf.lit != jlexer.TokenString {
+			return nil, p.errorf("%s takes a string", name)
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("expected )")
+		}
+		leaf.fn = name
+		if name == "test" {
+			if leaf.re, err = regexp.Compile(leaf.str); err != nil {
+				return nil, p.errorf("bad regexp: %s", err)
+			}
+		}
+	default:
+		for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+			if p.consume(op) {
+				leaf.fn = op
+				if err := p.parseLiteral(leaf); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+	}
+
+	node.leaves = append(node.leaves, len(p.f.leaves))
+	e := &filterExpr{op: "leaf", leaf: len(p.f.leaves)}
+	p.f.leaves = append(p.f.leaves, leaf)
+	if negate {
+		e = &filterExpr{op: "not", args: []*filterExpr{e}}
+	}
+	return e, nil
+}
+
+// parsePath разбирает .a.b[].c[0] и возвращает узел дерева путей
+func (p *filterParser) parsePath() (*pathNode, error) {
+	if !p.consume(".") {
+		return nil, p.errorf("expected path starting with .")
+	}
+	node := p.f.paths
+	if name := p.ident(); name != "" {
+		node = node.field(name)
+	}
+	for p.pos < len(p.src) {
+		switch {
+		case p.src[p.pos] == '.':
+			p.pos++
+			name := p.ident()
+			if name == "" {
+				return nil, p.errorf("expected field name")
+			}
+			node = node.field(name)
+		case strings.HasPrefix(p.src[p.pos:], "[]"):
+			p.pos += 2
+			if node.iterate == nil {
+				node.iterate = &pathNode{}
+			}
+			node = node.iterate
+		case p.src[p.pos] == '[':
+			p.pos++
+			idx, err := strconv.Atoi(p.ident())
+			if err != nil || idx < 0 || !p.consume("]") {
+				return nil, p.errorf("expected array index")
+			}
+			if node.index == nil {
+				node.index = make(map[int]*pathNode)
+			}
+			if node.index[idx] == nil {
+				node.index[idx] = &pathNode{}
+			}
+			node = node.index[idx]
+		default:
+			return node, nil
+		}
+	}
+	return node, nil
+}
+
+func (n *pathNode) field(name string) *pathNode {
+	if n.fields == nil {
+		n.fields = make(map[string]*pathNode)
+	}
+	if n.fields[name] == nil {
+		n.fields[name] = &pathNode{}
+	}
+	return n.fields[name]
+}
+
+func (p *filterParser) parseLiteral(leaf *filterLeaf) error {
+	p.skipSpaces()
+	switch {
+	case p.consume("null"):
+		leaf.lit = jlexer.TokenNull
+	case p.consume("true"):
+		leaf.lit, leaf.b = jlexer.TokenBool, true
+	case p.consume("false"):
+		leaf.lit = jlexer.TokenBool
+	case p.pos < len(p.src) && p.src[p.pos] == '"':
+		end := p.pos + 1
+		for end < len(p.src) && p.src[end] != '"' {
+			if p.src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.src) {
+			return p.errorf("unterminated string")
+		}
+		if err := json.Unmarshal([]byte(p.src[p.pos:end+1]), &leaf.str); err != nil {
+			return p.errorf("bad string: %s", err)
+		}
+		leaf.lit = jlexer.TokenString
+		p.pos = end + 1
+	default:
+		end := p.pos
+		for end < len(p.src) && strings.IndexByte("+-.0123456789eE", p.src[end]) >= 0 {
+			end++
+		}
+		n, err := strconv.ParseFloat(p.src[p.pos:end], 64)
+		if err != nil {
+			return p.errorf("expected literal")
+		}
+		leaf.lit, leaf.num = jlexer.TokenNumber, n
+		p.pos = end
+	}
+	return nil
+}
+
+// Match проверяет одну строку NDJSON
+func (f *Filter) Match(line []byte) (bool, error) {
+	for i := range f.results {
+		f.results[i], f.seen[i] = false, false
+	}
+	in := jlexer.Lexer{Data: line}
+	f.walk(&in, f.paths)
+	in.Consumed()
+	if err := in.Error(); err != nil {
+		return false, err
+	}
+	for i, leaf := range f.leaves {
+		if !f.seen[i] {
+			f.results[i] = leaf.match(jlexer.TokenNull, "", 0, false)
+		}
+	}
+	return f.eval(f.root), nil
+}
+
+func (f *Filter) eval(e *filterExpr) bool {
+	switch e.op {
+	case "and":
+		return f.eval(e.args[0]) && f.eval(e.args[1])
+	case "or":
+		return f.eval(e.args[0]) || f.eval(e.args[1])
+	case "not":
+		return !f.eval(e.args[0])
+	}
+	return f.results[e.leaf]
+}
+
+// walk проходит значение в текущей позиции лексера по узлу путей
+func (f *Filter) walk(in *jlexer.Lexer, node *pathNode) {
+	kind := in.CurrentToken()
+	isObject := kind == jlexer.TokenDelim && in.IsDelim('{')
+	isArray := kind == jlexer.TokenDelim && in.IsDelim('[')
+	if !isObject && !isArray {
+		f.scalar(in, node, kind)
+		return
+	}
+
+	// составное значение: для листьев оно есть и не null
+	for _, i := range node.leaves {
+		f.seen[i] = true
+		f.results[i] = f.results[i] || f.leaves[i].match(jlexer.TokenDelim, "", 0, false)
+	}
+	if node.empty() {
+		in.SkipRecursive()
+		return
+	}
+
+	if isObject {
+		in.Delim('{')
+		for !in.IsDelim('}') {
+			key := in.UnsafeFieldName(false)
+			in.WantColon()
+			f.walkValue(in, node.fields[key], node.iterate)
+			in.WantComma()
+		}
+		in.Delim('}')
+		return
+	}
+	in.Delim('[')
+	for i := 0; !in.IsDelim(']'); i++ {
+		f.walkValue(in, node.index[i], node.iterate)
+		in.WantComma()
+	}
+	in.Delim(']')
+}
+
+// walkValue отдает значение одному или двум узлам, например .a[0] и .a[] сразу
+func (f *Filter) walkValue(in *jlexer.Lexer, a, b *pathNode) {
+	switch {
+	case a == nil && b == nil:
+		in.SkipRecursive()
+	case b == nil:
+		f.walk(in, a)
+	case a == nil:
+		f.walk(in, b)
+	default:
+		raw := in.Raw()
+		for _, node := range [2]*pathNode{a, b} {
+			sub := jlexer.Lexer{Data: raw}
+			f.walk(&sub, node)
+			if err := sub.Error(); err != nil {
+				in.AddError(err)
+			}
+		}
+	}
+}
+
+func (f *Filter) scalar(in *jlexer.Lexer, node *pathNode, kind jlexer.TokenKind) {
+	s, n, b := "", 0.0, false
+	switch kind {
+	case jlexer.TokenString:
+		s = in.UnsafeString()
+	case jlexer.TokenNumber:
+		n = in.Float64()
+	case jlexer.TokenBool:
+		b = in.Bool()
+	default:
+		in.Skip()
+	}
+	for _, i := range node.leaves {
+		f.seen[i] = true
+		f.results[i] = f.results[i] || f.leaves[i].match(kind, s, n, b)
+	}
+}
+
+// match проверяет одно значение, для составных kind - TokenDelim
+func (l *filterLeaf) match(kind jlexer.TokenKind, s string, n float64, b bool) bool {
+	switch l.fn {
+	case "":
+		return kind != jlexer.TokenNull && (kind != jlexer.TokenBool || b)
+	case "contains":
+		return kind == jlexer.TokenString && strings.Contains(s, l.str)
+	case "startswith":
+		return kind == jlexer.TokenString && strings.HasPrefix(s, l.str)
+	case "endswith":
+		return kind == jlexer.TokenString && strings.HasSuffix(s, l.str)
+	case "test":
+		return kind == jlexer.TokenString && l.re.MatchString(s)
+	case "==":
+		return l.equal(kind, s, n, b)
+	case "!=":
+		return !l.equal(kind, s, n, b)
+	}
+
+	cmp := 0
+	switch {
+	case kind == jlexer.TokenNumber && l.lit == jlexer.TokenNumber:
+		if n < l.num {
+			cmp = -1
+		} else if n > l.num {
+			cmp = 1
+		}
+	case kind == jlexer.TokenString && l.lit == jlexer.TokenString:
+		cmp = strings.Compare(s, l.str)
+	default:
+		return false
+	}
+	switch l.fn {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+func (l *filterLeaf) equal(kind jlexer.TokenKind, s string, n float64, b bool) bool {
+	if kind != l.lit {
+		return false
+	}
+	switch kind {
+	case jlexer.TokenString:
+		return s == l.str
+	case jlexer.TokenNumber:
+		return n == l.num
+	case jlexer.TokenBool:
+		return b == l.b
+	}
+	return true
+}
+
+// FilterStats - итоги FilterLines
+type FilterStats struct {
+	Lines   int
+	Matched int
+	// битые строки, которые пропустил нестрогий режим
+	Malformed []*LineError
+}
+
+// FilterLines пишет в out строки из r, подошедшие под фильтр. countOnly - только считать.
+// gzip распаковывается сам, ошибки как у Search
+func FilterLines(ctx context.Context, r io.Reader, f *Filter, out io.Writer, opts SearchOptions, countOnly bool) (FilterStats, error) {
+	stats := FilterStats{}
+	in, err := maybeGzip(r)
+	if err != nil {
+		return stats, &ReadError{Line: 1, Err: err}
+	}
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		stats.Lines++
+		if stats.Lines%ctxCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return stats, err
+			}
+		}
+		line := scanner.Bytes()
+		ok, err := f.Match(line)
+		if err != nil {
+			lineErr := &LineError{Line: stats.Lines, Err: err}
+			if !opts.Lenient {
+				return stats, lineErr
+			}
+			stats.Malformed = append(stats.Malformed, lineErr)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		stats.Matched++
+		if !countOnly {
+			w.Write(line)
+			w.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, &ReadError{Line: stats.Lines + 1, Err: err}
+	}
+	return stats, w.Flush()
+}
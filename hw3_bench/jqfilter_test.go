@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFilterDataset(t *testing.T) {
+	users := referenceUsers(t)
+	anyBrowser := func(u map[string]interface{}, match func(string) bool) bool {
+		for _, b := range browsers(u) {
+			if match(b) {
+				return true
+			}
+		}
+		return false
+	}
+
+	cases := []struct {
+		filter string
+		match  func(u map[string]interface{}) bool
+	}{
+		{
+			`.browsers[] | contains("MSIE")`,
+			func(u map[string]interface{}) bool {
+				return anyBrowser(u, func(b string) bool { return strings.Contains(b, "MSIE") })
+			},
+		},
+		{
+			`.country == "Kenya"`,
+			func(u map[string]interface{}) bool { return u["country"] == "Kenya" },
+		},
+		{
+			`select(.browsers[] | contains("Android") and .browsers[] | contains("MSIE"))`,
+			func(u map[string]interface{}) bool {
+				return anyBrowser(u, func(b string) bool { return strings.Contains(b, "Android") }) &&
+					anyBrowser(u, func(b string) bool { return strings.Contains(b, "MSIE") })
+			},
+		},
+		{
+			`.browsers[0] | startswith("Opera") or not (.company < "M" or .name | test("^A"))`,
+			func(u map[string]interface{}) bool {
+				bs := browsers(u)
+				return len(bs) > 0 && strings.HasPrefix(bs[0], "Opera") ||
+					!(u["company"].(string) < "M" || strings.HasPrefix(u["name"].(string), "A"))
+			},
+		},
+	}
+
+	for _, c := range cases {
+		f, err := ParseFilter(c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := new(bytes.Buffer)
+		stats, err := FilterLines(context.Background(), file, f, out, SearchOptions{}, false)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := 0
+		for _, u := range users {
+			if c.match(u) {
+				expected++
+			}
+		}
+		if stats.Lines != len(users) || stats.Matched != expected || expected == 0 {
+			t.Errorf("%s: matched %d of %d, expected %d of %d", c.filter, stats.Matched, stats.Lines, expected, len(users))
+		}
+		if lines := strings.Count(out.String(), "\n"); lines != expected {
+			t.Errorf("%s: printed %d lines, expected %d", c.filter, lines, expected)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	line := []byte(`{"a":{"b":[1,{"c":"x"},null]},"n":2.5,"t":true,"f":false,"z":null,"s":"Hello \"w\"","skip":{"deep":[[{}]]}}`)
+	cases := map[string]bool{
+		`.a`:                         true,
+		`.a.b[1].c == "x"`:           true,
+		`.a.b[].c == "x"`:            true,
+		`.a.b[0] == 1 and .a.b[]`:    true,
+		`.a.b[2]`:                    false,
+		`.a.b[5] == null`:            true,
+		`.missing`:                   false,
+		`.missing | not`:             true,
+		`.a.missing != "x"`:          true,
+		`.n > 2 and .n <= 2.5`:       true,
+		`.n == "2.5"`:                false,
+		`.t == true and .f == false`: true,
+		`.f`:                         false,
+		`.z == null`:                 true,
+		`.s | endswith("\"w\"")`:     true,
+		`.s | test("^H.l+o")`:        true,
+		`.a[]`:                       true,
+		`.[] | contains("Hello")`:    true,
+		`.a.b | contains("x")`:       false,
+		`not .t or .n < 0`:           false,
+	}
+	for src, expected := range cases {
+		f, err := ParseFilter(src)
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		got, err := f.Match(line)
+		if err != nil || got != expected {
+			t.Errorf("%s: got %v, %v, expected %v", src, got, err, expected)
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	bad := []string{
+		``,
+		`a == 1`,
+		`.a ==`,
+		`.a | length`,
+		`.a | contains(1)`,
+		`.a | test("(")`,
+		`(.a`,
+		`.a[x]`,
+		`.a == "x`,
+		`.a extra`,
+	}
+	for _, src := range bad {
+		_, err := ParseFilter(src)
+		filterErr := &FilterError{}
+		if !errors.As(err, &filterErr) {
+			t.Errorf("%q: expected FilterError, got %v", src, err)
+		}
+	}
+
+	f, _ := ParseFilter(`.a`)
+	data := "{\"a\":1}\n{\"a\":\n{\"a\":2}\n"
+	_, err := FilterLines(context.Background(), strings.NewReader(data), f, new(bytes.Buffer), SearchOptions{}, true)
+	lineErr := &LineError{}
+	if !errors.As(err, &lineErr) || lineErr.Line != 2 {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+	stats, err := FilterLines(context.Background(), strings.NewReader(data), f, new(bytes.Buffer), SearchOptions{Lenient: true}, true)
+	if err != nil || stats.Matched != 2 || len(stats.Malformed) != 1 {
+		t.Errorf("lenient: %+v, %v", stats, err)
+	}
+}
+
+func TestFilterAllocs(t *testing.T) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := data[:bytes.IndexByte(data, '\n')]
+	f, err := ParseFilter(`.browsers[] | contains("MSIE") and .country == "Kenya"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		f.Match(line)
+	})
+	if allocs != 0 {
+		t.Errorf("Match allocates %v times per line", allocs)
+	}
+}
+
+func BenchmarkFilter(b *testing.B) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	f, err := ParseFilter(`.browsers[] | contains("Android") and .browsers[] | contains("MSIE")`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FilterLines(context.Background(), bytes.NewReader(data), f, new(bytes.Buffer), SearchOptions{}, true)
+	}
+}
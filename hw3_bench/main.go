@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command - подкоманда, получает аргументы после своего имени
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":      {"serve [-addr host:port] [file|-]", serveCommand},
+	"report":     {"report [-json] [-top N] [file|-]", reportCommand},
+	"generate":   {"generate [-seed N] [-users N] [-browsers weights.json | -sample users.txt] -out file", generateCommand},
+	"columnar":   {"columnar -out file [file|-]", columnarCommand},
+	"follow":     {"follow [-browser s]... [-poll d] [-every d] [-lenient] file", followCommand},
+	"filter":     {"filter [-c] [-lenient] 'expr' [file|-]", filterCommand},
+	"profile":    {"profile [-dir dir] [-label l] [-benchtime t] [-memprofilerate N]", profileCommand},
+	"profdiff":   {"profdiff [-bench Slow,Fast] [-top N] old-dir new-dir", profDiffCommand},
+	"benchcheck": {"benchcheck [-bench re] [-count N] [-history file] [-baseline] [-input file]", benchCheckCommand},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("usage: go test -bench . -benchmem")
+		names := []string{}
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println("       hw3_bench", commands[name].usage)
+		}
+		return
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Println("unknown command", os.Args[1])
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// openInput открывает файл из аргументов, "-" - stdin, без аргумента - датасет
+func openInput(flags *flag.FlagSet) (io.ReadCloser, error) {
+	switch path := flags.Arg(0); path {
+	case "":
+		return os.Open(filePath)
+	case "-":
+		return os.Stdin, nil
+	default:
+		return os.Open(path)
+	}
+}
+
+func reportCommand(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "вывести отчет в JSON")
+	top := flags.Int("top", 10, "длина списков в отчете, 0 - все")
+	flags.Parse(args)
+
+	in, err := openInput(flags)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	report, err := BuildBrowserReport(context.Background(), in, *top)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return report.WriteJSON(os.Stdout)
+	}
+	return report.WriteText(os.Stdout)
+}
+
+func benchCheckCommand(args []string) error {
+	flags := flag.NewFlagSet("benchcheck", flag.ExitOnError)
+	bench := flags.String("bench", ".", "какие бенчмарки запускать")
+	count := flags.Int("count", 5, "сколько раз запускать каждый бенчмарк")
+	historyPath := flags.String("history", "bench_history.json", "файл с историей запусков")
+	label := flags.String("label", "", "метка запуска, по умолчанию время")
+	setBaseline := flags.Bool("baseline", false, "сделать этот запуск базовым для следующих")
+	input := flags.String("input", "", "взять готовый вывод go test -bench из файла вместо запуска")
+	t := BenchThresholds{}
+	flags.Float64Var(&t.Time, "time-threshold", 5, "допустимый рост ns/op, %")
+	flags.Float64Var(&t.Alloc, "alloc-threshold", 0, "допустимый рост B/op и allocs/op, %")
+	flags.Float64Var(&t.Alpha, "alpha", 0.05, "уровень значимости")
+	flags.Parse(args)
+
+	var output io.Reader
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	} else {
+		cmd := exec.Command("go", "test", "-run", "^$", "-bench", *bench, "-benchmem", "-count", strconv.Itoa(*count))
+		cmd.Stderr = os.Stderr
+		data, err := cmd.Output()
+		if err != nil {
+			os.Stdout.Write(data)
+			return fmt.Errorf("go test: %s", err)
+		}
+		output = bytes.NewReader(data)
+	}
+
+	results, err := ParseBenchOutput(output)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no benchmark results")
+	}
+
+	history, err := LoadBenchHistory(*historyPath)
+	if err != nil {
+		return err
+	}
+	run := &BenchRun{Label: *label, Time: time.Now(), Results: results}
+	if run.Label == "" {
+		run.Label = run.Time.Format(time.RFC3339)
+	}
+	history.Runs = append(history.Runs, run)
+	base := history.BaselineFor(run)
+	if *setBaseline {
+		history.Baseline = run.Label
+	}
+	if err := history.Save(*historyPath); err != nil {
+		return err
+	}
+
+	if base == nil {
+		fmt.Println("saved first run", run.Label)
+		return nil
+	}
+	fmt.Printf("%s vs %s\n", run.Label, base.Label)
+	deltas := CompareBenchRuns(base, run)
+	WriteBenchDeltas(os.Stdout, deltas, t)
+	if regressions := t.Regressions(deltas); len(regressions) > 0 {
+		for _, d := range regressions {
+			fmt.Printf("regression: %s %s %+.2f%%\n", d.Name, d.Unit, d.Change)
+		}
+		return fmt.Errorf("%d regressions against %s", len(regressions), base.Label)
+	}
+	return nil
+}
+
+func serveCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "адрес сервера")
+	flags.Parse(args)
+
+	in, err := openInput(flags)
+	if err != nil {
+		return err
+	}
+	server, err := NewSearchServer(in)
+	in.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("starting server at", *addr)
+	return http.ListenAndServe(*addr, server)
+}
+
+// generateCommand пишет данные в -out, а ожидаемый вывод FastSearch - рядом в .expected.
+// Файл с расширением .gz сжимается
+func generateCommand(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	cfg := GenConfig{}
+	flags.Int64Var(&cfg.Seed, "seed", 1, "зерно генератора")
+	flags.IntVar(&cfg.Users, "users", 1000000, "сколько пользователей сгенерировать")
+	flags.IntVar(&cfg.BrowsersPerUser, "per-user", 4, "браузеров у пользователя")
+	weightsPath := flags.String("browsers", "", "JSON с весами браузеров [{\"ua\": ..., \"weight\": ...}]")
+	samplePath := flags.String("sample", "", "взять частоты браузеров из файла с пользователями")
+	outPath := flags.String("out", "", "куда писать пользователей")
+	flags.Parse(args)
+	if *outPath == "" {
+		return fmt.Errorf("-out is required")
+	}
+
+	for _, src := range []struct {
+		path string
+		read func(io.Reader) ([]BrowserWeight, error)
+	}{{*weightsPath, ReadBrowserWeights}, {*samplePath, BrowserWeightsFrom}} {
+		if src.path == "" {
+			continue
+		}
+		file, err := os.Open(src.path)
+		if err != nil {
+			return err
+		}
+		cfg.Browsers, err = src.read(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", src.path, err)
+		}
+	}
+
+	data, err := os.Create(*outPath)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	expected, err := os.Create(strings.TrimSuffix(*outPath, ".gz") + ".expected")
+	if err != nil {
+		return err
+	}
+	defer expected.Close()
+
+	var out io.Writer = data
+	var zw *gzip.Writer
+	if strings.HasSuffix(*outPath, ".gz") {
+		zw = gzip.NewWriter(data)
+		out = zw
+	}
+	stats, err := GenerateUsers(cfg, out, expected)
+	if err != nil {
+		return err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d users, %d found, %d unique browsers\n", stats.Lines, stats.Found, stats.UniqueBrowsers)
+	return data.Close()
+}
+
+func columnarCommand(args []string) error {
+	flags := flag.NewFlagSet("columnar", flag.ExitOnError)
+	outPath := flags.String("out", "", "куда писать колоночный файл")
+	flags.Parse(args)
+	if *outPath == "" {
+		return fmt.Errorf("-out is required")
+	}
+
+	in, err := openInput(flags)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(*outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	n, err := ConvertColumnar(in, out)
+	if err != nil {
+		return err
+	}
+	fmt.Println(n, "users converted")
+	return out.Close()
+}
+
+// stringsFlag - флаг, который можно указать несколько раз
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// followCommand печатает найденных по мере дописывания файла, итоги - в stderr
+func followCommand(args []string) error {
+	flags := flag.NewFlagSet("follow", flag.ExitOnError)
+	browsers := stringsFlag{}
+	flags.Var(&browsers, "browser", "подстрока браузера, все должны найтись у пользователя (по умолчанию Android и MSIE)")
+	f := &Follower{Out: os.Stdout, Totals: os.Stderr}
+	flags.DurationVar(&f.Poll, "poll", defaultFollowPoll, "как часто проверять файл")
+	flags.DurationVar(&f.Every, "every", defaultFollowEvery, "как часто печатать итоги")
+	flags.BoolVar(&f.Lenient, "lenient", false, "пропускать битые строки")
+	flags.Parse(args)
+
+	f.Path = flags.Arg(0)
+	if f.Path == "" {
+		f.Path = filePath
+	}
+	f.Query = AndroidAndMSIE
+	if len(browsers) > 0 {
+		conds := []*Expr{}
+		for _, browser := range browsers {
+			conds = append(conds, Contains("browsers", browser))
+		}
+		f.Query = Query{Where: And(conds...)}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := f.Run(ctx); err != context.Canceled {
+		return err
+	}
+	return nil
+}
+
+// filterCommand печатает строки, подошедшие под выражение ParseFilter, с -c - только их число
+func filterCommand(args []string) error {
+	flags := flag.NewFlagSet("filter", flag.ExitOnError)
+	count := flags.Bool("c", false, "только число подошедших строк")
+	lenient := flags.Bool("lenient", false, "пропускать битые строки")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("filter expression is required")
+	}
+
+	f, err := ParseFilter(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var in io.ReadCloser
+	switch path := flags.Arg(1); path {
+	case "":
+		in, err = os.Open(filePath)
+	case "-":
+		in = os.Stdin
+	default:
+		in, err = os.Open(path)
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stats, err := FilterLines(context.Background(), in, f, os.Stdout, SearchOptions{Lenient: *lenient}, *count)
+	if err != nil {
+		return err
+	}
+	if *count {
+		fmt.Println(stats.Matched)
+	}
+	for _, lineErr := range stats.Malformed {
+		fmt.Fprintln(os.Stderr, "skipped:", lineErr)
+	}
+	return nil
+}
+
+// profileCommand снимает профили BenchmarkSlow и BenchmarkFast в dir/label
+func profileCommand(args []string) error {
+	flags := flag.NewFlagSet("profile", flag.ExitOnError)
+	dir := flags.String("dir", "profiles", "каталог со снимками профилей")
+	label := flags.String("label", "", "имя снимка, по умолчанию время")
+	benchtime := flags.String("benchtime", "1s", "сколько гонять каждый бенчмарк")
+	memRate := flags.Int("memprofilerate", 0, "1 - записывать каждую аллокацию, 0 - как в runtime")
+	flags.Parse(args)
+	if *label == "" {
+		*label = time.Now().Format("20060102-150405")
+	}
+
+	out := filepath.Join(*dir, *label)
+	cmd := exec.Command("go", "test", "-run", "^$", "-bench", "^Benchmark(Slow|Fast)$", "-benchmem",
+		"-benchtime", *benchtime, "-memprofilerate", strconv.Itoa(*memRate), "-profiles", out)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go test: %s", err)
+	}
+	fmt.Println("profiles saved to", out)
+	return nil
+}
+
+// profDiffCommand показывает функции, у которых сильнее всего изменились время и аллокации
+func profDiffCommand(args []string) error {
+	flags := flag.NewFlagSet("profdiff", flag.ExitOnError)
+	benches := flags.String("bench", "Fast", "какие бенчмарки сравнивать, через запятую")
+	top := flags.Int("top", 15, "сколько функций показывать, 0 - все")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		return fmt.Errorf("need old and new profile dirs")
+	}
+
+	for _, name := range strings.Split(*benches, ",") {
+		if err := DiffProfileDirs(os.Stdout, flags.Arg(0), flags.Arg(1), name, *top); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"io/ioutil"
+	"strconv"
 	"testing"
 )
 
@@ -30,17 +32,80 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestSearchParallel(t *testing.T) {
+	fastOut := new(bytes.Buffer)
+	FastSearch(fastOut)
+	fastResult := fastOut.String()
+
+	for _, workers := range []int{1, 3, 8, 64} {
+		parallelOut := new(bytes.Buffer)
+		FastSearchParallel(parallelOut, workers)
+		if parallelResult := parallelOut.String(); parallelResult != fastResult {
+			t.Errorf("results not match for %d workers\nGot:\n%v\nExpected:\n%v", workers, parallelResult, fastResult)
+		}
+	}
+}
+
+func TestUserUnmarshalJSONCopies(t *testing.T) {
+	data := []byte(`{"name":"Ann","email":"ann@example.com","browsers":["Opera"]}`)
+	u := &user{}
+	if err := u.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	// строки не должны меняться вместе с переиспользованным буфером
+	for i := range data {
+		data[i] = 'x'
+	}
+	if u.Name != "Ann" || u.Email != "ann@example.com" || len(u.Browsers) != 1 || u.Browsers[0] != "Opera" {
+		t.Errorf("user changed with buffer: %+v", u)
+	}
+}
+
 // -----
 // go test -bench . -benchmem
+// go test -bench 'Slow|Fast$' -profiles profiles/new - еще и профили, сравнивать через profdiff
+
+var profileDir = flag.String("profiles", "", "каталог для cpu, heap и allocs профилей BenchmarkSlow и BenchmarkFast")
+
+// profileBench снимает профили бенчмарка только по -profiles, без флага бенчмарки идут
+// как обычно. Запуск и запись профилей в ns/op и allocs/op не попадают
+func profileBench(b *testing.B, name string) func() {
+	if *profileDir == "" {
+		return func() {}
+	}
+	p, err := StartBenchProfile(*profileDir, name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	return func() {
+		b.StopTimer()
+		if err := p.Stop(b.N); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
 
 func BenchmarkSlow(b *testing.B) {
+	defer profileBench(b, "Slow")()
 	for i := 0; i < b.N; i++ {
 		SlowSearch(ioutil.Discard)
 	}
 }
 
 func BenchmarkFast(b *testing.B) {
+	defer profileBench(b, "Fast")()
 	for i := 0; i < b.N; i++ {
 		FastSearch(ioutil.Discard)
 	}
 }
+
+func BenchmarkFastParallel(b *testing.B) {
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(strconv.Itoa(workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				FastSearchParallel(ioutil.Discard, workers)
+			}
+		})
+	}
+}
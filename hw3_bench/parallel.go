@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// FastSearchParallel - FastSearch, который разбирает файл на workers ядрах
+func FastSearchParallel(out io.Writer, workers int) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		panic(err)
+	}
+	SearchQueryParallel(file, info.Size(), AndroidAndMSIE, out, workers)
+}
+
+// chunkResult - найденное в одном куске файла, номера строк от начала куска
+type chunkResult struct {
+	lines  int
+	idxs   []int
+	ends   []int
+	fields []byte
+	state  *queryState
+}
+
+// SearchQueryParallel - SearchQuery, который режет вход по границам строк на куски и
+// разбирает их на workers горутинах. Результаты выводятся в порядке строк исходного файла.
+// workers <= 0 означает по числу ядер
+func SearchQueryParallel(r io.ReaderAt, size int64, q Query, out io.Writer, workers int) Stats {
+	query, err := compileQuery(q)
+	if err != nil {
+		panic(err)
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// кусков больше, чем воркеров, чтобы медленный кусок не держал всех
+	bounds := splitLines(r, size, workers*4)
+	chunks := len(bounds) - 1
+	results := make([]chan *chunkResult, chunks)
+	for i := range results {
+		results[i] = make(chan *chunkResult, 1)
+	}
+
+	tasks := make(chan int)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				results[i] <- scanChunk(io.NewSectionReader(r, bounds[i], bounds[i+1]-bounds[i]), query)
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < chunks; i++ {
+			tasks <- i
+		}
+		close(tasks)
+	}()
+
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	w.WriteString("found users:\n")
+
+	stats := Stats{}
+	unique := make(map[string]struct{})
+	line := []byte{}
+	for i := range results {
+		res := <-results[i]
+		start := 0
+		for j, idx := range res.idxs {
+			line = append(line[:0], '[')
+			line = strconv.AppendInt(line, int64(stats.Lines+idx), 10)
+			line = append(line, ']')
+			line = append(line, res.fields[start:res.ends[j]]...)
+			line = append(line, '\n')
+			w.Write(line)
+			start = res.ends[j]
+		}
+		for browser := range res.state.uniqueBrowsers {
+			unique[browser] = struct{}{}
+		}
+		stats.Lines += res.lines
+		stats.Found += len(res.idxs)
+	}
+	wg.Wait()
+
+	stats.UniqueBrowsers = len(unique)
+	fmt.Fprintln(w, "\nTotal unique browsers", stats.UniqueBrowsers)
+	return stats
+}
+
+func scanChunk(in io.Reader, query *compiledQuery) *chunkResult {
+	res := &chunkResult{state: newQueryState(query)}
+	lines, err := scanUsers(context.Background(), in, query, res.state, nil, func(idx int, u *user) {
+		res.idxs = append(res.idxs, idx)
+		res.fields = query.appendFields(res.fields, u)
+		res.ends = append(res.ends, len(res.fields))
+	})
+	if err != nil {
+		panic(err)
+	}
+	res.lines = lines
+	return res
+}
+
+// splitLines делит [0, size) примерно на parts кусков так, чтобы каждый начинался
+// с новой строки. Возвращает границы кусков, первая 0, последняя size
+func splitLines(r io.ReaderAt, size int64, parts int) []int64 {
+	bounds := []int64{0}
+	for i := 1; i < parts; i++ {
+		off := size * int64(i) / int64(parts)
+		prev := bounds[len(bounds)-1]
+		if off <= prev {
+			continue
+		}
+		// ищем конец строки, в которую попало смещение
+		reader := bufio.NewReader(io.NewSectionReader(r, off-1, size-off+1))
+		skipped := int64(0)
+		line, err := reader.ReadSlice('\n')
+		for err == bufio.ErrBufferFull {
+			skipped += int64(len(line))
+			line, err = reader.ReadSlice('\n')
+		}
+		if err != nil {
+			break
+		}
+		next := off - 1 + skipped + int64(len(line))
+		if next >= size {
+			break
+		}
+		bounds = append(bounds, next)
+	}
+	return append(bounds, size)
+}
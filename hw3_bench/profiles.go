@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Профили бенчмарка лежат в одном каталоге как <name>.cpu.pprof, <name>.heap.pprof и
+// <name>.allocs.pprof, рядом <name>.allocs-base.pprof - аллокации до запуска, чтобы смотреть
+// только аллокации бенчмарка через go tool pprof -base, и <name>.iterations - число итераций,
+// чтобы сравнивать на одну операцию. Разбирает профили go tool pprof
+var profileKinds = []string{"cpu", "heap", "allocs"}
+
+const allocsBaseKind = "allocs-base"
+
+// съемка профилей и запись cpu профиля в фоне
+var profilerFuncs = `\.(StartBenchProfile|writeMemProfile|\(\*BenchProfile\)\.Stop)$|^runtime/pprof\.profileWriter$`
+
+// BenchProfile снимает профили одного запуска бенчмарка
+type BenchProfile struct {
+	dir, name string
+	cpu       *os.File
+}
+
+func profilePath(dir, name, kind string) string {
+	return filepath.Join(dir, name+"."+kind+".pprof")
+}
+
+func iterationsPath(dir, name string) string {
+	return filepath.Join(dir, name+".iterations")
+}
+
+// StartBenchProfile начинает снимать профили, бенчмарк с несколькими запусками
+// перезаписывает их, так что остается последний, самый длинный
+func StartBenchProfile(dir, name string) (*BenchProfile, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	p := &BenchProfile{dir: dir, name: name}
+	if err := writeMemProfile(profilePath(dir, name, allocsBaseKind), "allocs"); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.cpu, err = os.Create(profilePath(dir, name, "cpu")); err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(p.cpu); err != nil {
+		p.cpu.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// writeMemProfile пишет профиль памяти после сборки мусора, иначе он отстает на цикл GC
+func writeMemProfile(path, name string) error {
+	runtime.GC()
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.Lookup(name).WriteTo(file, 0); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Stop заканчивает съемку, iterations - b.N запуска
+func (p *BenchProfile) Stop(iterations int) error {
+	pprof.StopCPUProfile()
+	if err := p.cpu.Close(); err != nil {
+		return err
+	}
+	for _, kind := range []string{"heap", "allocs"} {
+		if err := writeMemProfile(profilePath(p.dir, p.name, kind), kind); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(iterationsPath(p.dir, p.name), []byte(strconv.Itoa(iterations)+"\n"), 0644)
+}
+
+// profileIterations - число итераций снимка name, без файла 1
+func profileIterations(dir, name string) (int, error) {
+	data, err := ioutil.ReadFile(iterationsPath(dir, name))
+	if os.IsNotExist(err) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s: bad iterations %q", iterationsPath(dir, name), data)
+	}
+	return n, nil
+}
+
+// FuncCost - затраты функции на одну итерацию: Flat - в ней самой, Cum - вместе с вызванными
+type FuncCost struct {
+	Flat, Cum float64
+}
+
+// profileCosts считает затраты функций снимка name на одну итерацию по типу сэмплов
+// sampleType (cpu, alloc_space, ...). Аллокации считаются от allocs-base, без съемки профилей
+func profileCosts(dir, name, kind, sampleType string) (map[string]FuncCost, error) {
+	iterations, err := profileIterations(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"tool", "pprof", "-top", "-nodecount=0", "-nodefraction=0",
+		"-sample_index=" + sampleType, "-ignore=" + profilerFuncs}
+	switch sampleType {
+	case "cpu":
+		args = append(args, "-unit=ns")
+	case "alloc_space", "inuse_space":
+		args = append(args, "-unit=B")
+	}
+	if kind == "allocs" {
+		args = append(args, "-base", profilePath(dir, name, allocsBaseKind))
+	}
+	path := profilePath(dir, name, kind)
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("go", append(args, path)...)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: pprof: %s %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return parsePprofTop(bytes.NewReader(out), float64(iterations))
+}
+
+// parsePprofTop разбирает таблицу go tool pprof -top и делит затраты на iterations
+func parsePprofTop(r io.Reader, iterations float64) (map[string]FuncCost, error) {
+	costs := map[string]FuncCost{}
+	table := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if !table {
+			table = len(fields) > 0 && fields[0] == "flat"
+			continue
+		}
+		if len(fields) < 6 {
+			continue
+		}
+		flat, err := parsePprofValue(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		cum, err := parsePprofValue(fields[3])
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(strings.Join(fields[5:], " "), " (inline)")
+		cost := costs[name]
+		cost.Flat += flat / iterations
+		cost.Cum += cum / iterations
+		costs[name] = cost
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !table {
+		return nil, fmt.Errorf("no pprof top table")
+	}
+	return costs, nil
+}
+
+// parsePprofValue - число из колонки -top без единицы: 120ns, 4096B, 17
+func parsePprofValue(s string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimRight(s, "nsB"), 64)
+}
+
+// FuncDelta - изменение затрат функции между двумя снимками
+type FuncDelta struct {
+	Name          string
+	Base, Current FuncCost
+}
+
+func (d FuncDelta) CumChange() float64 {
+	return d.Current.Cum - d.Base.Cum
+}
+
+// DiffProfiles возвращает top функций с наибольшим по модулю изменением cum, 0 - все
+func DiffProfiles(baseCosts, currentCosts map[string]FuncCost, top int) []FuncDelta {
+	deltas := []FuncDelta{}
+	for name, cost := range baseCosts {
+		deltas = append(deltas, FuncDelta{Name: name, Base: cost, Current: currentCosts[name]})
+	}
+	for name, cost := range currentCosts {
+		if _, ok := baseCosts[name]; !ok {
+			deltas = append(deltas, FuncDelta{Name: name, Current: cost})
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		ci, cj := math.Abs(deltas[i].CumChange()), math.Abs(deltas[j].CumChange())
+		if ci != cj {
+			return ci > cj
+		}
+		return deltas[i].Name < deltas[j].Name
+	})
+	if top > 0 && top < len(deltas) {
+		deltas = deltas[:top]
+	}
+	return deltas
+}
+
+// profileReports - что сравнивает DiffProfileDirs: вид профиля, тип сэмплов, единица на операцию
+var profileReports = []struct {
+	kind, sampleType, unit string
+}{
+	{"cpu", "cpu", "ns/op"},
+	{"allocs", "alloc_space", "B/op"},
+	{"allocs", "alloc_objects", "allocs/op"},
+}
+
+// DiffProfileDirs пишет в out сравнение профилей бенчмарка name из двух каталогов
+func DiffProfileDirs(out io.Writer, baseDir, currentDir, name string, top int) error {
+	baseIterations, err := profileIterations(baseDir, name)
+	if err != nil {
+		return err
+	}
+	currentIterations, err := profileIterations(currentDir, name)
+	if err != nil {
+		return err
+	}
+	for _, r := range profileReports {
+		base, err := profileCosts(baseDir, name, r.kind, r.sampleType)
+		if err != nil {
+			return err
+		}
+		current, err := profileCosts(currentDir, name, r.kind, r.sampleType)
+		if err != nil {
+			return err
+		}
+		deltas := DiffProfiles(base, current, top)
+
+		fmt.Fprintf(out, "%s %s (%d -> %d iterations)\n", name, r.unit, baseIterations, currentIterations)
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "function\tcum old\tcum new\tdelta\tflat old\tflat new\t")
+		for _, d := range deltas {
+			fmt.Fprintf(w, "%s\t%.0f\t%.0f\t%s\t%.0f\t%.0f\t\n",
+				d.Name, d.Base.Cum, d.Current.Cum, formatChange(d.Base.Cum, d.Current.Cum), d.Base.Flat, d.Current.Flat)
+		}
+		w.Flush()
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
+func formatChange(base, current float64) string {
+	switch {
+	case base == 0 && current == 0:
+		return "~"
+	case base == 0:
+		return "new"
+	case current == 0:
+		return "gone"
+	}
+	return fmt.Sprintf("%+.1f%%", (current-base)/base*100)
+}
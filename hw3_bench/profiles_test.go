@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDiffProfiles(t *testing.T) {
+	base := map[string]FuncCost{
+		"main.Search":  {100, 650},
+		"main.decode":  {300, 300},
+		"main.match":   {0, 200},
+		"regexp.Match": {200, 200},
+		"main.walk":    {50, 50},
+	}
+	current := map[string]FuncCost{
+		"main.Search": {100, 310},
+		"main.decode": {200, 200},
+		"main.fast":   {10, 10},
+	}
+
+	deltas := DiffProfiles(base, current, 0)
+	expected := []FuncDelta{
+		{"main.Search", FuncCost{100, 650}, FuncCost{100, 310}},
+		{"main.match", FuncCost{0, 200}, FuncCost{}},
+		{"regexp.Match", FuncCost{200, 200}, FuncCost{}},
+		{"main.decode", FuncCost{300, 300}, FuncCost{200, 200}},
+		{"main.walk", FuncCost{50, 50}, FuncCost{}},
+		{"main.fast", FuncCost{}, FuncCost{10, 10}},
+	}
+	if len(deltas) != len(expected) {
+		t.Fatalf("got %d deltas: %+v", len(deltas), deltas)
+	}
+	for i := range expected {
+		if deltas[i] != expected[i] {
+			t.Errorf("delta %d: got %+v, expected %+v", i, deltas[i], expected[i])
+		}
+	}
+
+	if deltas := DiffProfiles(base, current, 2); len(deltas) != 2 {
+		t.Errorf("top 2 returned %d", len(deltas))
+	}
+}
+
+func TestParsePprofTop(t *testing.T) {
+	top := `Type: cpu
+Showing nodes accounting for 3610000000ns, 100% of 3610000000ns total
+      flat  flat%   sum%        cum   cum%
+740000000ns 20.50% 20.50% 740000000ns 20.50%  main.decode (inline)
+ 20000000ns  0.55% 21.05% 3560000000ns 98.61%  main.Search
+         0     0% 21.05%  -40000000ns -1.1%  regexp.(*Regexp).Match
+`
+	costs, err := parsePprofTop(strings.NewReader(top), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]FuncCost{
+		"main.decode":            {370000000, 370000000},
+		"main.Search":            {10000000, 1780000000},
+		"regexp.(*Regexp).Match": {0, -20000000},
+	}
+	if len(costs) != len(expected) {
+		t.Fatalf("got %+v", costs)
+	}
+	for name, cost := range expected {
+		if costs[name] != cost {
+			t.Errorf("%s: got %+v, expected %+v", name, costs[name], cost)
+		}
+	}
+	if _, err := parsePprofTop(strings.NewReader("no table"), 1); err == nil {
+		t.Error("expected error without table")
+	}
+}
+
+func TestBenchProfile(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	searches := []func(){
+		func() { SlowSearch(ioutil.Discard) },
+		func() { FastSearch(ioutil.Discard) },
+	}
+	for i, dir := range dirs {
+		p, err := StartBenchProfile(dir, "Search")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 5; j++ {
+			searches[i]()
+		}
+		if err := p.Stop(5); err != nil {
+			t.Fatal(err)
+		}
+		for _, kind := range append(profileKinds, allocsBaseKind) {
+			if _, err := os.Stat(profilePath(dir, "Search", kind)); err != nil {
+				t.Error(err)
+			}
+		}
+		if n, err := profileIterations(dir, "Search"); n != 5 || err != nil {
+			t.Errorf("iterations %d, %v", n, err)
+		}
+	}
+
+	allocs := map[string]FuncDelta{}
+	base, err := profileCosts(dirs[0], "Search", "allocs", "alloc_space")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := profileCosts(dirs[1], "Search", "allocs", "alloc_space")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range DiffProfiles(base, current, 0) {
+		// в тестах пакет называется по модулю, а не main
+		allocs[d.Name[strings.LastIndexByte(d.Name, '.')+1:]] = d
+	}
+	// SlowSearch аллоцирует сотни мегабайт, FastSearch - меньше мегабайта
+	if slow := allocs["SlowSearch"]; slow.Base.Cum < 1<<20 || slow.Current.Cum != 0 {
+		t.Errorf("SlowSearch allocs: %+v", slow)
+	}
+	if fast := allocs["FastSearch"]; fast.Base.Cum != 0 || fast.Current.Cum >= allocs["SlowSearch"].Base.Cum/10 {
+		t.Errorf("FastSearch allocs: %+v", fast)
+	}
+
+	out := new(bytes.Buffer)
+	if err := DiffProfileDirs(out, dirs[0], dirs[1], "Search", 5); err != nil {
+		t.Fatal(err)
+	}
+	for _, unit := range []string{"Search ns/op (5 -> 5 iterations)", "Search B/op", "Search allocs/op", ".SlowSearch"} {
+		if !strings.Contains(out.String(), unit) {
+			t.Errorf("no %q in report:\n%s", unit, out)
+		}
+	}
+	if err := DiffProfileDirs(out, dirs[0], t.TempDir(), "Search", 5); err == nil {
+		t.Error("expected error for missing profiles")
+	}
+	if _, err := profileCosts(dirs[0], "Search", "cpu", "alloc_space"); err == nil {
+		t.Error("expected error for missing sample type")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// NameCount - сколько пользователей попало в значение Name
+type NameCount struct {
+	Name  string `json:"name"`
+	Users int    `json:"users"`
+}
+
+type FamilyReport struct {
+	Family string `json:"family"`
+	Users  int    `json:"users"`
+	// распределение по старшей версии
+	Versions []NameCount `json:"versions"`
+}
+
+// PairCount - сколько пользователей пользуются обоими семействами
+type PairCount struct {
+	Families [2]string `json:"families"`
+	Users    int       `json:"users"`
+}
+
+// BrowserReport - аналитика по браузерам. Все числа - количество пользователей,
+// у которых есть хотя бы один подходящий браузер
+type BrowserReport struct {
+	Users          int            `json:"users"`
+	UniqueBrowsers int            `json:"unique_browsers"`
+	Families       []FamilyReport `json:"families"`
+	OS             []NameCount    `json:"os"`
+	Devices        []NameCount    `json:"devices"`
+	CoOccurrence   []PairCount    `json:"co_occurrence"`
+}
+
+// counter считает пользователей по значениям, каждого не больше раза на значение
+type counter map[string]int
+
+func (c counter) top(n int) []NameCount {
+	result := make([]NameCount, 0, len(c))
+	for name, users := range c {
+		result = append(result, NameCount{Name: name, Users: users})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Users != result[j].Users {
+			return result[i].Users > result[j].Users
+		}
+		return result[i].Name < result[j].Name
+	})
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// BuildBrowserReport разбирает браузеры всех пользователей из r. top ограничивает
+// длину каждого списка в отчете, 0 - без ограничений
+func BuildBrowserReport(ctx context.Context, r io.Reader, top int) (*BrowserReport, error) {
+	query, err := compileQuery(Query{Fields: []string{"browsers"}})
+	if err != nil {
+		return nil, err
+	}
+	in, err := maybeGzip(r)
+	if err != nil {
+		return nil, &ReadError{Line: 1, Err: err}
+	}
+
+	parsed := make(map[string]UserAgent)
+	families := counter{}
+	versions := make(map[string]counter)
+	systems := counter{}
+	devices := counter{}
+	pairs := counter{}
+
+	// что уже посчитано у текущего пользователя, ключ - раздел и значение
+	userFamilies := []string{}
+	userSeen := make(map[string]bool)
+	add := func(c counter, section, value string) bool {
+		key := section + "\x00" + value
+		if userSeen[key] {
+			return false
+		}
+		userSeen[key] = true
+		c[value]++
+		return true
+	}
+
+	lines, err := scanUsers(ctx, in, query, newQueryState(query), nil, func(idx int, u *user) {
+		userFamilies = userFamilies[:0]
+		for k := range userSeen {
+			delete(userSeen, k)
+		}
+		for _, browser := range u.Browsers {
+			ua, ok := parsed[browser]
+			if !ok {
+				browser = strings.Clone(browser)
+				ua = ParseUserAgent(browser)
+				parsed[browser] = ua
+			}
+			if add(families, "family", ua.Family) {
+				userFamilies = append(userFamilies, ua.Family)
+			}
+			if versions[ua.Family] == nil {
+				versions[ua.Family] = counter{}
+			}
+			version := ua.MajorVersion()
+			if version == "" {
+				version = "unknown"
+			}
+			add(versions[ua.Family], "version "+ua.Family, version)
+			add(systems, "os", ua.OS)
+			add(devices, "device", ua.Device)
+		}
+
+		sort.Strings(userFamilies)
+		for i, a := range userFamilies {
+			for _, b := range userFamilies[i+1:] {
+				pairs[a+"\x00"+b]++
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BrowserReport{
+		Users:          lines,
+		UniqueBrowsers: len(parsed),
+		OS:             systems.top(top),
+		Devices:        devices.top(top),
+	}
+	for _, family := range families.top(top) {
+		report.Families = append(report.Families, FamilyReport{
+			Family:   family.Name,
+			Users:    family.Users,
+			Versions: versions[family.Name].top(top),
+		})
+	}
+	for _, pair := range pairs.top(top) {
+		names := strings.SplitN(pair.Name, "\x00", 2)
+		report.CoOccurrence = append(report.CoOccurrence, PairCount{
+			Families: [2]string{names[0], names[1]},
+			Users:    pair.Users,
+		})
+	}
+	return report, nil
+}
+
+func (r *BrowserReport) WriteJSON(out io.Writer) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *BrowserReport) WriteText(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	percent := func(users int) string {
+		if r.Users == 0 {
+			return "0%"
+		}
+		return fmt.Sprintf("%.1f%%", float64(users)*100/float64(r.Users))
+	}
+
+	fmt.Fprintf(w, "users %d, unique browsers %d\n", r.Users, r.UniqueBrowsers)
+
+	fmt.Fprintln(w, "\nfamilies:")
+	for _, f := range r.Families {
+		versions := make([]string, len(f.Versions))
+		for i, v := range f.Versions {
+			versions[i] = fmt.Sprintf("%s: %d", v.Name, v.Users)
+		}
+		fmt.Fprintf(w, "  %s\t%d\t%s\t%s\n", f.Family, f.Users, percent(f.Users), strings.Join(versions, ", "))
+	}
+
+	for _, section := range []struct {
+		title string
+		list  []NameCount
+	}{{"os", r.OS}, {"devices", r.Devices}} {
+		fmt.Fprintf(w, "\n%s:\n", section.title)
+		for _, c := range section.list {
+			fmt.Fprintf(w, "  %s\t%d\t%s\n", c.Name, c.Users, percent(c.Users))
+		}
+	}
+
+	fmt.Fprintln(w, "\nused together:")
+	for _, p := range r.CoOccurrence {
+		fmt.Fprintf(w, "  %s + %s\t%d\t%s\n", p.Families[0], p.Families[1], p.Users, percent(p.Users))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	jlexer "github.com/mailru/easyjson/jlexer"
+)
+
+type fieldSet uint8
+
+const (
+	fieldName fieldSet = 1 << iota
+	fieldEmail
+	fieldBrowsers
+	fieldCompany
+	fieldCountry
+	fieldJob
+	fieldPhone
+
+	allFields = fieldName | fieldEmail | fieldBrowsers | fieldCompany | fieldCountry | fieldJob | fieldPhone
+)
+
+var fieldNames = map[string]fieldSet{
+	"name":     fieldName,
+	"email":    fieldEmail,
+	"browsers": fieldBrowsers,
+	"company":  fieldCompany,
+	"country":  fieldCountry,
+	"job":      fieldJob,
+	"phone":    fieldPhone,
+}
+
+// values возвращает значения поля пользователя, у browsers их несколько
+func (f fieldSet) values(u *user, single *[1]string) []string {
+	switch f {
+	case fieldBrowsers:
+		return u.Browsers
+	case fieldName:
+		single[0] = u.Name
+	case fieldEmail:
+		single[0] = u.Email
+	case fieldCompany:
+		single[0] = u.Company
+	case fieldCountry:
+		single[0] = u.Country
+	case fieldJob:
+		single[0] = u.Job
+	case fieldPhone:
+		single[0] = u.Phone
+	}
+	return single[:]
+}
+
+// Expr - условие поиска: лист сравнивает поле со значением, остальные объединяют условия
+type Expr struct {
+	Op    string
+	Args  []*Expr
+	Field string
+	Value string
+
+	re *regexp.Regexp
+}
+
+// для browsers лист выполняется, если подходит хотя бы один браузер
+func Contains(field, value string) *Expr {
+	return &Expr{Op: "contains", Field: field, Value: value}
+}
+
+func Prefix(field, value string) *Expr {
+	return &Expr{Op: "prefix", Field: field, Value: value}
+}
+
+func Regex(field, pattern string) *Expr {
+	return &Expr{Op: "regex", Field: field, Value: pattern}
+}
+
+func And(args ...*Expr) *Expr {
+	return &Expr{Op: "and", Args: args}
+}
+
+func Or(args ...*Expr) *Expr {
+	return &Expr{Op: "or", Args: args}
+}
+
+func Not(arg *Expr) *Expr {
+	return &Expr{Op: "not", Args: []*Expr{arg}}
+}
+
+func (e *Expr) String() string {
+	switch e.Op {
+	case "and", "or", "not":
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = arg.String()
+		}
+		return e.Op + "(" + strings.Join(args, ", ") + ")"
+	}
+	return e.Op + "(" + e.Field + ", " + strconv.Quote(e.Value) + ")"
+}
+
+// Query - условие и поля, которые выводятся по найденным пользователям
+type Query struct {
+	Where *Expr
+	// по умолчанию name и email
+	Fields []string
+}
+
+// AndroidAndMSIE - запрос, который раньше был зашит в FastSearch
+var AndroidAndMSIE = Query{
+	Where:  And(Contains("browsers", "Android"), Contains("browsers", "MSIE")),
+	Fields: []string{"name", "email"},
+}
+
+// Stats - итоги поиска. UniqueBrowsers считает все разные браузеры, которые подошли
+// хотя бы под одно условие по browsers, у всех пользователей, а не только у найденных
+type Stats struct {
+	Lines          int
+	Found          int
+	UniqueBrowsers int
+	// битые строки, которые пропустил нестрогий режим
+	Malformed []*LineError
+}
+
+// compiledQuery - запрос, готовый к выполнению: листья считаются для каждого
+// пользователя целиком, а дерево условий потом смотрит только на их результаты
+type compiledQuery struct {
+	where  *Expr
+	leaves []*Expr
+	fields []fieldSet
+	want   fieldSet
+}
+
+func compileQuery(q Query) (*compiledQuery, error) {
+	c := &compiledQuery{where: q.Where}
+
+	names := q.Fields
+	if len(names) == 0 {
+		names = []string{"name", "email"}
+	}
+	for _, name := range names {
+		f, ok := fieldNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown output field %q", name)
+		}
+		c.fields = append(c.fields, f)
+		c.want |= f
+	}
+
+	if q.Where != nil {
+		if err := c.compile(q.Where); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *compiledQuery) compile(e *Expr) error {
+	switch e.Op {
+	case "and", "or":
+		for _, arg := range e.Args {
+			if err := c.compile(arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "not":
+		if len(e.Args) != 1 {
+			return fmt.Errorf("not takes one argument, got %d", len(e.Args))
+		}
+		return c.compile(e.Args[0])
+	case "contains", "prefix":
+	case "regex":
+		re, err := regexp.Compile(e.Value)
+		if err != nil {
+			return fmt.Errorf("bad regex in %s: %s", e, err)
+		}
+		e.re = re
+	default:
+		return fmt.Errorf("unknown operation %q", e.Op)
+	}
+
+	f, ok := fieldNames[e.Field]
+	if !ok {
+		return fmt.Errorf("unknown field %q in %s", e.Field, e)
+	}
+	c.want |= f
+	c.leaves = append(c.leaves, e)
+	return nil
+}
+
+func (e *Expr) matchValue(value string) bool {
+	switch e.Op {
+	case "contains":
+		return strings.Contains(value, e.Value)
+	case "prefix":
+		return strings.HasPrefix(value, e.Value)
+	default:
+		return e.re.MatchString(value)
+	}
+}
+
+// queryState - то, что копится при проходе по пользователям
+type queryState struct {
+	query          *compiledQuery
+	leafResults    map[*Expr]bool
+	uniqueBrowsers map[string]struct{}
+}
+
+func newQueryState(q *compiledQuery) *queryState {
+	return &queryState{
+		query:          q,
+		leafResults:    make(map[*Expr]bool, len(q.leaves)),
+		uniqueBrowsers: make(map[string]struct{}),
+	}
+}
+
+func (s *queryState) match(u *user) bool {
+	single := [1]string{}
+	for _, leaf := range s.query.leaves {
+		f := fieldNames[leaf.Field]
+		matched := false
+		for _, value := range f.values(u, &single) {
+			if !leaf.matchValue(value) {
+				continue
+			}
+			matched = true
+			if f != fieldBrowsers {
+				break
+			}
+			if _, seen := s.uniqueBrowsers[value]; !seen {
+				s.uniqueBrowsers[strings.Clone(value)] = struct{}{}
+			}
+		}
+		s.leafResults[leaf] = matched
+	}
+	if s.query.where == nil {
+		return true
+	}
+	return s.eval(s.query.where)
+}
+
+func (s *queryState) eval(e *Expr) bool {
+	switch e.Op {
+	case "and":
+		for _, arg := range e.Args {
+			if !s.eval(arg) {
+				return false
+			}
+		}
+		return true
+	case "or":
+		for _, arg := range e.Args {
+			if s.eval(arg) {
+				return true
+			}
+		}
+		return false
+	case "not":
+		return !s.eval(e.Args[0])
+	}
+	return s.leafResults[e]
+}
+
+// appendLine дописывает строку вывода "[idx] поля"
+func (q *compiledQuery) appendLine(buf []byte, idx int, u *user) []byte {
+	buf = append(buf, '[')
+	buf = strconv.AppendInt(buf, int64(idx), 10)
+	buf = append(buf, ']')
+	buf = q.appendFields(buf, u)
+	return append(buf, '\n')
+}
+
+// appendFields дописывает выводимые поля через пробел; email выводится как <user [at] host>
+func (q *compiledQuery) appendFields(buf []byte, u *user) []byte {
+	single := [1]string{}
+	for _, f := range q.fields {
+		buf = append(buf, ' ')
+		switch f {
+		case fieldEmail:
+			buf = append(buf, '<')
+			at := strings.IndexByte(u.Email, '@')
+			if at < 0 {
+				buf = append(buf, u.Email...)
+			} else {
+				buf = append(buf, u.Email[:at]...)
+				buf = append(buf, " [at] "...)
+				buf = append(buf, u.Email[at+1:]...)
+			}
+			buf = append(buf, '>')
+		case fieldBrowsers:
+			buf = append(buf, strings.Join(u.Browsers, ", ")...)
+		default:
+			buf = append(buf, f.values(u, &single)[0]...)
+		}
+	}
+	return buf
+}
+
+// LineError - строка Line (с единицы) не разбирается как пользователь
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// ReadError - вход оборвался на строке Line, продолжать после нее нельзя
+type ReadError struct {
+	Line int
+	Err  error
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("read line %d: %s", e.Line, e.Err)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// как часто scanUsers проверяет, не отменен ли контекст
+const ctxCheckLines = 256
+
+// scanUsers разбирает строки из in и вызывает found для подошедших пользователей
+// с номером строки от начала in. Если malformed задан, битые строки передаются ему
+// и пропускаются, иначе разбор останавливается на первой. Возвращает число прочитанных строк
+func scanUsers(ctx context.Context, in io.Reader, query *compiledQuery, state *queryState,
+	malformed func(err *LineError), found func(idx int, u *user)) (int, error) {
+	scanner := bufio.NewScanner(in)
+	u := &user{}
+	idx := 0
+	for ; scanner.Scan(); idx++ {
+		if idx%ctxCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return idx, err
+			}
+		}
+		*u = user{Browsers: u.Browsers[:0]}
+		lexer := jlexer.Lexer{Data: scanner.Bytes()}
+		easyjson9e1087fdDecodeFakeCom(&lexer, u, query.want, true)
+		if err := lexer.Error(); err != nil {
+			lineErr := &LineError{Line: idx + 1, Err: err}
+			if malformed == nil {
+				return idx, lineErr
+			}
+			malformed(lineErr)
+			continue
+		}
+		if state.match(u) {
+			found(idx, u)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return idx, &ReadError{Line: idx + 1, Err: err}
+	}
+	return idx, nil
+}
+
+// SearchOptions - необязательные настройки Search
+type SearchOptions struct {
+	// Lenient пропускает битые строки и складывает их ошибки в Stats.Malformed
+	Lenient bool
+}
+
+// Search ищет пользователей по запросу в NDJSON из r и пишет результат в том же формате,
+// что и FastSearch. Вход в gzip распаковывается сам. Ошибки разбора - *LineError,
+// ошибки чтения - *ReadError, при отмене ctx возвращается ctx.Err()
+func Search(ctx context.Context, r io.Reader, q Query, out io.Writer) (Stats, error) {
+	return SearchWithOptions(ctx, r, q, out, SearchOptions{})
+}
+
+func SearchWithOptions(ctx context.Context, r io.Reader, q Query, out io.Writer, opts SearchOptions) (Stats, error) {
+	stats := Stats{}
+	query, err := compileQuery(q)
+	if err != nil {
+		return stats, err
+	}
+	in, err := maybeGzip(r)
+	if err != nil {
+		return stats, &ReadError{Line: 1, Err: err}
+	}
+
+	w := bufio.NewWriter(out)
+	w.WriteString("found users:\n")
+
+	var malformed func(err *LineError)
+	if opts.Lenient {
+		malformed = func(err *LineError) {
+			stats.Malformed = append(stats.Malformed, err)
+		}
+	}
+
+	state := newQueryState(query)
+	line := []byte{}
+	stats.Lines, err = scanUsers(ctx, in, query, state, malformed, func(idx int, u *user) {
+		stats.Found++
+		line = query.appendLine(line[:0], idx, u)
+		w.Write(line)
+	})
+	stats.UniqueBrowsers = len(state.uniqueBrowsers)
+	if err != nil {
+		w.Flush()
+		return stats, err
+	}
+
+	fmt.Fprintln(w, "\nTotal unique browsers", stats.UniqueBrowsers)
+	return stats, w.Flush()
+}
+
+// maybeGzip распаковывает r, если он начинается с заголовка gzip
+func maybeGzip(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+	return buffered, nil
+}
+
+// SearchQuery - Search без контекста, который паникует на ошибках
+func SearchQuery(in io.Reader, q Query, out io.Writer) Stats {
+	stats, err := Search(context.Background(), in, q, out)
+	if err != nil {
+		panic(err)
+	}
+	return stats
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// referenceUsers читает датасет через encoding/json для сверки результатов запросов
+func referenceUsers(t *testing.T) []map[string]interface{} {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	users := []map[string]interface{}{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		u := map[string]interface{}{}
+		if err := json.Unmarshal(scanner.Bytes(), &u); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	return users
+}
+
+func runQuery(t *testing.T, q Query) (string, Stats) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	out := new(bytes.Buffer)
+	stats := SearchQuery(file, q, out)
+	return out.String(), stats
+}
+
+func TestSearchQuery(t *testing.T) {
+	users := referenceUsers(t)
+	kenya := regexp.MustCompile("^K.*a$")
+
+	cases := []struct {
+		query Query
+		match func(u map[string]interface{}) bool
+	}{
+		{
+			Query{Where: Or(Contains("browsers", "Opera"), Prefix("company", "Fl"))},
+			func(u map[string]interface{}) bool {
+				return strings.Contains(strings.Join(browsers(u), "\n"), "Opera") ||
+					strings.HasPrefix(u["company"].(string), "Fl")
+			},
+		},
+		{
+			Query{Where: And(Regex("country", "^K.*a$"), Not(Contains("email", ".com"))), Fields: []string{"country", "email"}},
+			func(u map[string]interface{}) bool {
+				return kenya.MatchString(u["country"].(string)) && !strings.Contains(u["email"].(string), ".com")
+			},
+		},
+	}
+
+	for _, c := range cases {
+		found := 0
+		for _, u := range users {
+			if c.match(u) {
+				found++
+			}
+		}
+		result, stats := runQuery(t, c.query)
+		if stats.Lines != len(users) || stats.Found != found || found == 0 {
+			t.Errorf("%s: expected %d of %d users, got %+v", c.query.Where, found, len(users), stats)
+		}
+		if got := strings.Count(result, "\n["); got != found {
+			t.Errorf("%s: expected %d output lines, got %d", c.query.Where, found, got)
+		}
+	}
+
+	result, _ := runQuery(t, Query{Where: Contains("country", "Kenya"), Fields: []string{"country", "email"}})
+	if !strings.Contains(result, "] Kenya <") || !strings.Contains(result, " [at] ") {
+		t.Errorf("unexpected projection:\n%s", result)
+	}
+}
+
+func browsers(u map[string]interface{}) []string {
+	result := []string{}
+	for _, b := range u["browsers"].([]interface{}) {
+		result = append(result, b.(string))
+	}
+	return result
+}
+
+func TestCompileQueryErrors(t *testing.T) {
+	bad := []Query{
+		{Where: Contains("age", "1")},
+		{Where: Regex("name", "(")},
+		{Where: &Expr{Op: "xor"}},
+		{Fields: []string{"password"}},
+	}
+	for _, q := range bad {
+		if _, err := compileQuery(q); err == nil {
+			t.Errorf("query %+v must not compile", q)
+		}
+	}
+}
+
+func TestSearchReader(t *testing.T) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := new(bytes.Buffer)
+	SearchQuery(bytes.NewReader(data), AndroidAndMSIE, expected)
+
+	zipped := new(bytes.Buffer)
+	zw := gzip.NewWriter(zipped)
+	zw.Write(data)
+	zw.Close()
+
+	out := new(bytes.Buffer)
+	if _, err := Search(context.Background(), zipped, AndroidAndMSIE, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected.String() {
+		t.Errorf("gzip result differs:\n%s", out.String())
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	input := `{"name":"a","browsers":["MSIE 8"]}
+{"name":"b","browsers":[
+{"name":"c","browsers":["Android 4"]}
+not json
+`
+	_, err := Search(context.Background(), strings.NewReader(input), AndroidAndMSIE, new(bytes.Buffer))
+	lineErr := &LineError{}
+	if !errors.As(err, &lineErr) || lineErr.Line != 2 {
+		t.Fatalf("expected error on line 2, got %v", err)
+	}
+
+	stats, err := SearchWithOptions(context.Background(), strings.NewReader(input), Query{}, new(bytes.Buffer), SearchOptions{Lenient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Lines != 4 || stats.Found != 2 || len(stats.Malformed) != 2 ||
+		stats.Malformed[0].Line != 2 || stats.Malformed[1].Line != 4 {
+		t.Errorf("unexpected lenient stats %+v", stats)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Search(ctx, strings.NewReader(input), Query{}, new(bytes.Buffer)); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
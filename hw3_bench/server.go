@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	jlexer "github.com/mailru/easyjson/jlexer"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+	// через сколько пользователей сбрасывать NDJSON клиенту
+	ndjsonFlushEvery = 256
+)
+
+// SearchServer отвечает на поиск по пользователям, которые один раз загружены в память
+type SearchServer struct {
+	// строки пользователей ссылаются на прочитанный буфер, а не копируются
+	users []user
+
+	// число пользователей по каждому браузеру, по убыванию
+	browsers []NameCount
+}
+
+// userJSON - пользователь в ответах сервера
+type userJSON struct {
+	Index    int      `json:"index"`
+	Name     string   `json:"name"`
+	Email    string   `json:"email"`
+	Browsers []string `json:"browsers"`
+	Company  string   `json:"company,omitempty"`
+	Country  string   `json:"country,omitempty"`
+	Job      string   `json:"job,omitempty"`
+	Phone    string   `json:"phone,omitempty"`
+}
+
+func newUserJSON(idx int, u *user) userJSON {
+	return userJSON{
+		Index:    idx,
+		Name:     u.Name,
+		Email:    u.Email,
+		Browsers: u.Browsers,
+		Company:  u.Company,
+		Country:  u.Country,
+		Job:      u.Job,
+		Phone:    u.Phone,
+	}
+}
+
+type searchPage struct {
+	Total          int        `json:"total"`
+	Offset         int        `json:"offset"`
+	Limit          int        `json:"limit"`
+	UniqueBrowsers int        `json:"unique_browsers"`
+	Users          []userJSON `json:"users"`
+}
+
+type serverStats struct {
+	Users          int `json:"users"`
+	UniqueBrowsers int `json:"unique_browsers"`
+	// только если в запросе были browser
+	Found           *int        `json:"found,omitempty"`
+	MatchedBrowsers *int        `json:"matched_browsers,omitempty"`
+	Browsers        []NameCount `json:"browsers"`
+}
+
+// NewSearchServer читает всех пользователей из r, gzip распаковывается сам
+func NewSearchServer(r io.Reader) (*SearchServer, error) {
+	in, err := maybeGzip(r)
+	if err != nil {
+		return nil, &ReadError{Line: 1, Err: err}
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, &ReadError{Line: 1, Err: err}
+	}
+
+	s := &SearchServer{}
+	counts := counter{}
+	decoded := &user{}
+	for line, rest := 1, data; len(rest) > 0; line++ {
+		end := bytes.IndexByte(rest, '\n')
+		if end < 0 {
+			end = len(rest)
+		}
+		*decoded = user{Browsers: decoded.Browsers[:0]}
+		lexer := jlexer.Lexer{Data: rest[:end]}
+		easyjson9e1087fdDecodeFakeCom(&lexer, decoded, allFields, true)
+		if err := lexer.Error(); err != nil {
+			return nil, &LineError{Line: line, Err: err}
+		}
+
+		u := *decoded
+		u.Browsers = append([]string(nil), decoded.Browsers...)
+		seen := make(map[string]bool, len(u.Browsers))
+		for _, browser := range u.Browsers {
+			if !seen[browser] {
+				seen[browser] = true
+				counts[browser]++
+			}
+		}
+		s.users = append(s.users, u)
+
+		if end == len(rest) {
+			break
+		}
+		rest = rest[end+1:]
+	}
+	s.browsers = counts.top(0)
+	return s, nil
+}
+
+func (s *SearchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/search":
+		s.handleSearch(w, r)
+	case "/stats":
+		s.handleStats(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// browserQuery - пользователи, у которых есть браузеры со всеми подстроками, как в FastSearch
+func browserQuery(r *http.Request) (*compiledQuery, error) {
+	conds := []*Expr{}
+	for _, browser := range r.URL.Query()["browser"] {
+		conds = append(conds, Contains("browsers", browser))
+	}
+	q := Query{Fields: []string{"name", "email"}}
+	if len(conds) > 0 {
+		q.Where = And(conds...)
+	}
+	return compileQuery(q)
+}
+
+// intParam читает неотрицательный параметр, если его нет - def
+func intParam(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad %s %q", name, value)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleSearch отдает страницу offset/limit в формате text (как FastSearch) или json.
+// ndjson отдает всех найденных начиная с offset потоком, limit=0 - без ограничения
+func (s *SearchServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query, err := browserQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	defLimit := defaultPageLimit
+	if format == "ndjson" {
+		defLimit = 0
+	}
+	offset, err := intParam(r, "offset", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := intParam(r, "limit", defLimit)
+	if err != nil || (format != "ndjson" && (limit == 0 || limit > maxPageLimit)) {
+		http.Error(w, fmt.Sprintf("limit must be 1..%d", maxPageLimit), http.StatusBadRequest)
+		return
+	}
+
+	switch format {
+	case "", "text":
+		s.searchText(w, query, offset, limit)
+	case "json":
+		s.searchJSON(w, query, offset, limit)
+	case "ndjson":
+		s.searchNDJSON(w, r, query, offset, limit)
+	default:
+		http.Error(w, "unknown format "+strconv.Quote(format), http.StatusBadRequest)
+	}
+}
+
+// each вызывает found для подошедших пользователей, пока found возвращает true
+func (s *SearchServer) each(query *compiledQuery, found func(idx int, u *user) bool) *queryState {
+	state := newQueryState(query)
+	for idx := range s.users {
+		if state.match(&s.users[idx]) && !found(idx, &s.users[idx]) {
+			break
+		}
+	}
+	return state
+}
+
+func (s *SearchServer) searchText(w http.ResponseWriter, query *compiledQuery, offset, limit int) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	out := bufio.NewWriter(w)
+	out.WriteString("found users:\n")
+	n := 0
+	line := []byte{}
+	state := s.each(query, func(idx int, u *user) bool {
+		if n >= offset && n < offset+limit {
+			line = query.appendLine(line[:0], idx, u)
+			out.Write(line)
+		}
+		n++
+		return true
+	})
+	fmt.Fprintln(out, "\nTotal unique browsers", len(state.uniqueBrowsers))
+	out.Flush()
+}
+
+func (s *SearchServer) searchJSON(w http.ResponseWriter, query *compiledQuery, offset, limit int) {
+	page := &searchPage{Offset: offset, Limit: limit, Users: []userJSON{}}
+	state := s.each(query, func(idx int, u *user) bool {
+		if page.Total >= offset && page.Total < offset+limit {
+			page.Users = append(page.Users, newUserJSON(idx, u))
+		}
+		page.Total++
+		return true
+	})
+	page.UniqueBrowsers = len(state.uniqueBrowsers)
+	writeJSON(w, page)
+}
+
+// searchNDJSON пишет по пользователю в строке и периодически сбрасывает их клиенту,
+// поэтому ответ не копится в памяти целиком. Ушедший клиент прерывает поиск
+func (s *SearchServer) searchNDJSON(w http.ResponseWriter, r *http.Request, query *compiledQuery, offset, limit int) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	n, sent := 0, 0
+	s.each(query, func(idx int, u *user) bool {
+		n++
+		if n <= offset {
+			return true
+		}
+		if err := enc.Encode(newUserJSON(idx, u)); err != nil || r.Context().Err() != nil {
+			return false
+		}
+		sent++
+		if flusher != nil && sent%ndjsonFlushEvery == 0 {
+			flusher.Flush()
+		}
+		return limit == 0 || sent < limit
+	})
+}
+
+// handleStats отдает число пользователей по каждому браузеру. С параметрами browser
+// еще и итоги поиска, как у FastSearch
+func (s *SearchServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	top, err := intParam(r, "top", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats := &serverStats{
+		Users:          len(s.users),
+		UniqueBrowsers: len(s.browsers),
+		Browsers:       s.browsers,
+	}
+	if top > 0 && top < len(stats.Browsers) {
+		stats.Browsers = stats.Browsers[:top]
+	}
+
+	if len(r.URL.Query()["browser"]) > 0 {
+		query, err := browserQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		found := 0
+		state := s.each(query, func(int, *user) bool {
+			found++
+			return true
+		})
+		matched := len(state.uniqueBrowsers)
+		stats.Found, stats.MatchedBrowsers = &found, &matched
+	}
+	writeJSON(w, stats)
+}
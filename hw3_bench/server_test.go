@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newTestSearchServer(t *testing.T) *httptest.Server {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	s, err := NewSearchServer(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func get(t *testing.T, url string, status int) []byte {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		t.Fatalf("%s: expected status %d, got %d: %s", url, status, resp.StatusCode, body)
+	}
+	return body
+}
+
+func TestSearchServer(t *testing.T) {
+	ts := newTestSearchServer(t)
+	query := ts.URL + "/search?browser=Android&browser=MSIE"
+
+	expected := new(bytes.Buffer)
+	FastSearch(expected)
+	if text := get(t, query, http.StatusOK); string(text) != expected.String() {
+		t.Errorf("text result differs from FastSearch:\n%s", text)
+	}
+
+	full := &searchPage{}
+	json.Unmarshal(get(t, query+"&format=json&limit=1000", http.StatusOK), full)
+	if full.Total == 0 || len(full.Users) != full.Total {
+		t.Fatalf("unexpected full page: total %d, users %d", full.Total, len(full.Users))
+	}
+	if full.UniqueBrowsers != 114 {
+		t.Errorf("expected 114 unique browsers, got %d", full.UniqueBrowsers)
+	}
+
+	paged := []userJSON{}
+	for offset := 0; offset < full.Total; offset += 2 {
+		page := &searchPage{}
+		json.Unmarshal(get(t, query+"&format=json&limit=2&offset="+strconv.Itoa(offset), http.StatusOK), page)
+		if page.Total != full.Total {
+			t.Fatalf("page total %d differs from %d", page.Total, full.Total)
+		}
+		paged = append(paged, page.Users...)
+	}
+	for i := range paged {
+		if i >= len(full.Users) || paged[i].Index != full.Users[i].Index {
+			t.Fatalf("pages do not match full result at %d", i)
+		}
+	}
+
+	streamed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(get(t, query+"&format=ndjson&offset=1", http.StatusOK)))
+	for scanner.Scan() {
+		u := userJSON{}
+		if err := json.Unmarshal(scanner.Bytes(), &u); err != nil {
+			t.Fatal(err)
+		}
+		if u.Index != full.Users[streamed+1].Index {
+			t.Fatalf("unexpected streamed user %+v", u)
+		}
+		streamed++
+	}
+	if streamed != full.Total-1 {
+		t.Errorf("expected %d streamed users, got %d", full.Total-1, streamed)
+	}
+
+	for _, bad := range []string{"&limit=0", "&limit=5000", "&offset=-1", "&format=xml"} {
+		get(t, query+bad, http.StatusBadRequest)
+	}
+}
+
+func TestSearchServerStats(t *testing.T) {
+	ts := newTestSearchServer(t)
+
+	stats := &serverStats{}
+	json.Unmarshal(get(t, ts.URL+"/stats?top=3", http.StatusOK), stats)
+	if stats.Users != 1000 || stats.UniqueBrowsers != 670 || len(stats.Browsers) != 3 || stats.Found != nil {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.Browsers[0].Users < stats.Browsers[1].Users {
+		t.Errorf("browsers are not sorted: %+v", stats.Browsers)
+	}
+
+	json.Unmarshal(get(t, ts.URL+"/stats?top=1&browser=Android&browser=MSIE", http.StatusOK), stats)
+	if stats.Found == nil || *stats.Found == 0 || *stats.MatchedBrowsers != 114 {
+		t.Errorf("unexpected query stats %+v", stats)
+	}
+
+	if body := get(t, ts.URL+"/nope", http.StatusNotFound); !strings.Contains(string(body), "not found") {
+		t.Errorf("unexpected 404 body %s", body)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// UserAgent - разобранная строка браузера
+type UserAgent struct {
+	Family  string `json:"family"`
+	Version string `json:"version,omitempty"`
+	OS      string `json:"os"`
+	Device  string `json:"device"`
+}
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceConsole = "console"
+	DeviceBot     = "bot"
+	DeviceOther   = "other"
+)
+
+type uaRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// правила проверяются по порядку, первая подошедшая побеждает. Версия - первая группа.
+// Порядок важен: Chrome пишет о себе Safari, Edge и Opera - Chrome, SeaMonkey - Firefox
+var familyRules = []uaRule{
+	{"Bot", regexp.MustCompile(`(?i)(?:bot|crawler|spider|slurp|fetcher|Mediapartners)[^/]*/?(\d+(?:\.\d+)?)?`)},
+	{"Edge", regexp.MustCompile(`Edge?/(\d+(?:\.\d+)?)`)},
+	{"Opera Mini", regexp.MustCompile(`Opera Mini/(\d+(?:\.\d+)?)`)},
+	{"Opera Mobile", regexp.MustCompile(`Opera Mobi.*Version/(\d+(?:\.\d+)?)|Opera Mobi`)},
+	{"Opera", regexp.MustCompile(`OPR/(\d+(?:\.\d+)?)|Opera.*Version/(\d+(?:\.\d+)?)|Opera[/ ](\d+(?:\.\d+)?)`)},
+	{"IE Mobile", regexp.MustCompile(`IEMobile[/ ](\d+(?:\.\d+)?)`)},
+	{"IE", regexp.MustCompile(`MSIE (\d+(?:\.\d+)?)|Trident/.*rv:(\d+(?:\.\d+)?)`)},
+	{"UC Browser", regexp.MustCompile(`UC ?Browser/(\d+(?:\.\d+)?)`)},
+	{"SeaMonkey", regexp.MustCompile(`SeaMonkey/(\d+(?:\.\d+)?)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|Iceweasel|Fennec)/(\d+(?:\.\d+)?)`)},
+	{"Chromium", regexp.MustCompile(`Chromium/(\d+(?:\.\d+)?)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/(\d+(?:\.\d+)?)`)},
+	{"Android Browser", regexp.MustCompile(`Android.*Version/(\d+(?:\.\d+)?).*Safari`)},
+	// Safari/N - номер сборки WebKit, а не версия, без Version/ версия неизвестна
+	{"Safari", regexp.MustCompile(`Version/(\d+(?:\.\d+)?).*Safari|Safari/`)},
+	{"Konqueror", regexp.MustCompile(`Konqueror/(\d+(?:\.\d+)?)`)},
+	{"NetFront", regexp.MustCompile(`NetFront/(\d+(?:\.\d+)?)`)},
+	{"Nokia Browser", regexp.MustCompile(`BrowserNG/(\d+(?:\.\d+)?)|Nokia`)},
+	{"Sony Ericsson", regexp.MustCompile(`SonyEricsson`)},
+	{"Samsung", regexp.MustCompile(`SAMSUNG|SEC-SGH`)},
+	{"Motorola", regexp.MustCompile(`MOT-`)},
+	// прочие браузеры на движке Gecko, версия - версия движка
+	{"Gecko", regexp.MustCompile(`rv:? ?(\d+(?:\.\d+)?)[^)]*\) Gecko`)},
+}
+
+var osRules = []uaRule{
+	{"Windows Phone", regexp.MustCompile(`Windows Phone(?: OS)? (\d+(?:\.\d+)?)`)},
+	{"Windows Mobile", regexp.MustCompile(`Windows CE|Windows Mobile`)},
+	{"Windows", regexp.MustCompile(`Windows NT (\d+\.\d+)|Win(?:dows|98|95|NT|32|64)`)},
+	{"iOS", regexp.MustCompile(`OS (\d+(?:_\d+)?)[_\d]* like Mac OS X`)},
+	{"iOS", regexp.MustCompile(`iPhone|iPad|iPod`)},
+	{"Android", regexp.MustCompile(`Android[ /]?(\d+(?:\.\d+)?)?`)},
+	{"Mac OS X", regexp.MustCompile(`Mac OS X (\d+(?:[_.]\d+)?)|Mac OS X|Macintosh`)},
+	{"Chrome OS", regexp.MustCompile(`CrOS`)},
+	{"BlackBerry", regexp.MustCompile(`BlackBerry|BB10|RIM Tablet`)},
+	{"Symbian", regexp.MustCompile(`Symbian(?:OS)?|Series ?60|S60`)},
+	{"FreeBSD", regexp.MustCompile(`FreeBSD`)},
+	{"OpenBSD", regexp.MustCompile(`OpenBSD`)},
+	{"NetBSD", regexp.MustCompile(`NetBSD`)},
+	{"Linux", regexp.MustCompile(`Linux|X11`)},
+	{"OS/2", regexp.MustCompile(`OS/2`)},
+}
+
+// версии Windows NT под их обычными названиями
+var windowsNames = map[string]string{
+	"5.0":  "2000",
+	"5.1":  "XP",
+	"5.2":  "XP",
+	"6.0":  "Vista",
+	"6.1":  "7",
+	"6.2":  "8",
+	"6.3":  "8.1",
+	"10.0": "10",
+}
+
+var (
+	botDevice     = regexp.MustCompile(`(?i)bot|crawler|spider|slurp|fetcher|Mediapartners`)
+	consoleDevice = regexp.MustCompile(`(?i)PlayStation|PSP|Nintendo|Xbox|\bwii\b`)
+	tabletDevice  = regexp.MustCompile(`iPad|Tablet|Kindle|Silk/|PlayBook`)
+	mobileDevice  = regexp.MustCompile(`Mobi|iPhone|iPod|Android|Windows Phone|Windows CE|IEMobile|Symbian|BlackBerry|MIDP|WAP|Opera Mini|BREW`)
+)
+
+// firstMatch возвращает имя первого подошедшего правила и первую непустую группу
+func firstMatch(rules []uaRule, s string) (string, string, bool) {
+	for _, rule := range rules {
+		m := rule.re.FindStringSubmatch(s)
+		if m == nil {
+			continue
+		}
+		for _, group := range m[1:] {
+			if group != "" {
+				return rule.name, strings.Replace(group, "_", ".", -1), true
+			}
+		}
+		return rule.name, "", true
+	}
+	return "", "", false
+}
+
+// ParseUserAgent определяет семейство браузера с версией major.minor, ОС и тип устройства.
+// Неизвестное семейство - первое слово строки до "/", неизвестная ОС - "Other"
+func ParseUserAgent(s string) UserAgent {
+	ua := UserAgent{OS: "Other", Device: DeviceOther}
+
+	family, version, ok := firstMatch(familyRules, s)
+	if !ok {
+		family = s
+		if i := strings.IndexAny(s, "/ ("); i > 0 {
+			family = s[:i]
+		}
+		if i := strings.IndexByte(s, '/'); i >= 0 {
+			version = s[i+1:]
+			if j := strings.IndexAny(version, " ;()"); j >= 0 {
+				version = version[:j]
+			}
+		}
+	}
+	ua.Family, ua.Version = family, version
+
+	if name, osVersion, ok := firstMatch(osRules, s); ok {
+		ua.OS = name
+		if name, ok := windowsNames[osVersion]; ok && ua.OS == "Windows" {
+			osVersion = name
+		}
+		if osVersion != "" {
+			ua.OS += " " + osVersion
+		}
+	}
+
+	switch {
+	case ua.Family == "Bot" || botDevice.MatchString(s):
+		ua.Device = DeviceBot
+	case consoleDevice.MatchString(s):
+		ua.Device = DeviceConsole
+	case tabletDevice.MatchString(s):
+		ua.Device = DeviceTablet
+	case mobileDevice.MatchString(s):
+		ua.Device = DeviceMobile
+	case ua.OS != "Other":
+		ua.Device = DeviceDesktop
+	}
+	return ua
+}
+
+// MajorVersion - версия до первой точки
+func (ua UserAgent) MajorVersion() string {
+	if i := strings.IndexByte(ua.Version, '.'); i >= 0 {
+		return ua.Version[:i]
+	}
+	return ua.Version
+}
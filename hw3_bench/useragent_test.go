@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		browser  string
+		expected UserAgent
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/36.0.1985.67 Safari/537.36",
+			UserAgent{"Chrome", "36.0", "Windows 7", DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Linux; U; Android 2.2; en-us; Nexus One Build/FRF91) AppleWebKit/533.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/533.1",
+			UserAgent{"Android Browser", "4.0", "Android 2.2", DeviceMobile},
+		},
+		{
+			"Mozilla/5.0 (iPad; CPU OS 9_3_2 like Mac OS X) AppleWebKit/601.1.46 (KHTML, like Gecko) Version/9.0 Mobile/13F69 Safari/601.1",
+			UserAgent{"Safari", "9.0", "iOS 9.3", DeviceTablet},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; U; PPC Mac OS X; en) AppleWebKit/418.8 (KHTML, like Gecko) Safari/419.3",
+			UserAgent{"Safari", "", "Mac OS X", DeviceDesktop},
+		},
+		{
+			"Mozilla/4.0 (compatible; MSIE 6.0; Windows CE; IEMobile 7.11) XV6800",
+			UserAgent{"IE Mobile", "7.11", "Windows Mobile", DeviceMobile},
+		},
+		{
+			"Mozilla/5.0 (compatible; MSIE 10.0; Windows NT 6.1; WOW64; Trident/6.0)",
+			UserAgent{"IE", "10.0", "Windows 7", DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (X11; FreeBSD i386; rv:28.0) Gecko/20100101 Firefox/28.0 SeaMonkey/2.25",
+			UserAgent{"SeaMonkey", "2.25", "FreeBSD", DeviceDesktop},
+		},
+		{
+			"Opera/9.80 (Windows NT 6.0) Presto/2.12.388 Version/12.14",
+			UserAgent{"Opera", "12.14", "Windows Vista", DeviceDesktop},
+		},
+		{
+			"Googlebot/2.1 ( http://www.googlebot.com/bot.html)",
+			UserAgent{"Bot", "2.1", "Other", DeviceBot},
+		},
+		{
+			"Mozilla/4.0 (PSP (PlayStation Portable); 2.00)",
+			UserAgent{"Mozilla", "4.0", "Other", DeviceConsole},
+		},
+		{
+			"Wget/1.9 cvs-stable (Red Hat modified)",
+			UserAgent{"Wget", "1.9", "Other", DeviceOther},
+		},
+	}
+	for _, c := range cases {
+		if got := ParseUserAgent(c.browser); got != c.expected {
+			t.Errorf("%s:\nexpected %+v\ngot      %+v", c.browser, c.expected, got)
+		}
+	}
+}
+
+func TestBrowserReport(t *testing.T) {
+	input := `{"browsers":["Mozilla/5.0 (X11; Linux x86_64; rv:52.0) Gecko/20100101 Firefox/52.0","Mozilla/5.0 (X11; Linux i686; rv:32.0) Gecko/20100101 Firefox/32.0"]}
+{"browsers":["Mozilla/5.0 (X11; Linux x86_64; rv:52.0) Gecko/20100101 Firefox/52.0","Mozilla/5.0 (Windows NT 5.1) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/36.0.1985.67 Safari/537.36"]}
+{"browsers":["Googlebot/2.1 ( http://www.googlebot.com/bot.html)"]}
+`
+	report, err := BuildBrowserReport(context.Background(), strings.NewReader(input), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Users != 3 || report.UniqueBrowsers != 4 {
+		t.Fatalf("unexpected totals %+v", report)
+	}
+	firefox := report.Families[0]
+	if firefox.Family != "Firefox" || firefox.Users != 2 ||
+		len(firefox.Versions) != 2 || firefox.Versions[0] != (NameCount{"52", 2}) {
+		t.Errorf("unexpected firefox stats %+v", firefox)
+	}
+	if len(report.CoOccurrence) != 1 || report.CoOccurrence[0] != (PairCount{[2]string{"Chrome", "Firefox"}, 1}) {
+		t.Errorf("unexpected co-occurrence %+v", report.CoOccurrence)
+	}
+	if report.Devices[0] != (NameCount{DeviceDesktop, 2}) {
+		t.Errorf("unexpected devices %+v", report.Devices)
+	}
+
+	text := new(bytes.Buffer)
+	if err := report.WriteText(text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "Chrome + Firefox") {
+		t.Errorf("unexpected text report:\n%s", text)
+	}
+	parsed := &BrowserReport{}
+	data := new(bytes.Buffer)
+	report.WriteJSON(data)
+	if err := json.Unmarshal(data.Bytes(), parsed); err != nil || parsed.Families[0].Family != "Firefox" {
+		t.Errorf("bad json report %v:\n%s", err, data)
+	}
+}
+
+func TestBrowserReportDataset(t *testing.T) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	report, err := BuildBrowserReport(context.Background(), file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := 0
+	for _, d := range report.Devices {
+		users += d.Users
+	}
+	if report.Users != 1000 || users < report.Users || len(report.Families) < 10 {
+		t.Errorf("unexpected dataset report: %d users, %d families", report.Users, len(report.Families))
+	}
+}
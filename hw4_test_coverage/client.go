@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,6 +19,9 @@ const (
 	orderDesc
 )
 
+// больше пользователей за запрос FindUsers не отдает
+const maxLimit = 25
+
 var (
 	errTest = errors.New("testing")
 	client  = &http.Client{Timeout: time.Second}
@@ -37,6 +42,8 @@ type SearchResponse struct {
 
 type SearchErrorResponse struct {
 	Error string
+	// параметр, который не понравился, для ErrorBadOrderField и ErrorBadFilter
+	Field string `json:",omitempty"`
 }
 
 const (
@@ -47,13 +54,128 @@ const (
 	ErrorBadOrderField = `OrderField invalid`
 )
 
+const (
+	// OrderFieldRelevance сортирует по BM25 оценке полнотекстового поиска, лучшие первыми
+	OrderFieldRelevance = "Relevance"
+
+	// QueryModeSubstring - Query ищется подстрокой в Name и About, как было всегда
+	QueryModeSubstring = "substring"
+	// QueryModeFullText - Query разбивается на слова, "фразы" и префиксы* и ищется по индексу
+	QueryModeFullText = "fulltext"
+)
+
+// ErrUnauthorized - внешняя система не приняла AccessToken
+var ErrUnauthorized = errors.New("Bad AccessToken")
+
+// BadOrderFieldError - внешняя система не умеет сортировать по Field
+type BadOrderFieldError struct {
+	Field string
+}
+
+func (e *BadOrderFieldError) Error() string {
+	return fmt.Sprintf("OrderField %s invalid", e.Field)
+}
+
+// BadFilterError - внешняя система не приняла фильтр Filter (age, gender, id)
+type BadFilterError struct {
+	Filter string
+}
+
+func (e *BadFilterError) Error() string {
+	return fmt.Sprintf("filter %s invalid", e.Filter)
+}
+
+// ServerError - внешняя система ответила ошибкой, которую клиент не разбирает
+type ServerError struct {
+	Status int
+	Body   string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("SearchServer error %d: %s", e.Status, e.Body)
+}
+
+// TimeoutError - не дождались ответа: таймаут клиента или дедлайн контекста. Err - причина
+type TimeoutError struct {
+	Params string
+	Err    error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timeout for %s: %s", e.Params, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout - как у net.Error
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
 type SearchRequest struct {
 	Limit      int
 	Offset     int    // Можно учесть после сортировки
 	Query      string // подстрока в 1 из полей
 	OrderField string
-	// -1 по убыванию, 0 как встретилось, 1 по возрастанию
+	// OrderByAsc (-1) по возрастанию, OrderByAsIs (0) как встретилось, OrderByDesc (1) по убыванию
 	OrderBy int
+	// пустой - QueryModeSubstring, с OrderFieldRelevance - QueryModeFullText
+	QueryMode string
+
+	// фильтры, нулевые значения не фильтруют. MinAge и MaxAge включительно
+	MinAge int
+	MaxAge int
+	Gender string
+	Ids    []int
+	// сортировка по нескольким полям, если задана - вместо OrderField и OrderBy
+	Sort []SortKey
+}
+
+// SortKey - поле сортировки и направление, в запросе как "Age desc,Name asc"
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+func (k SortKey) String() string {
+	if k.Desc {
+		return k.Field + " desc"
+	}
+	return k.Field + " asc"
+}
+
+// addFilters кодирует фильтры и сортировку в параметры запроса
+func addFilters(params url.Values, req SearchRequest) {
+	if req.MinAge != 0 || req.MaxAge != 0 {
+		age := ""
+		if req.MinAge != 0 {
+			age = strconv.Itoa(req.MinAge)
+		}
+		age += "-"
+		if req.MaxAge != 0 {
+			age += strconv.Itoa(req.MaxAge)
+		}
+		params.Add("age", age)
+	}
+	if req.Gender != "" {
+		params.Add("gender", req.Gender)
+	}
+	if len(req.Ids) > 0 {
+		ids := make([]string, 0, len(req.Ids))
+		for _, id := range req.Ids {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		params.Add("id", strings.Join(ids, ","))
+	}
+	if len(req.Sort) > 0 {
+		keys := make([]string, 0, len(req.Sort))
+		for _, key := range req.Sort {
+			keys = append(keys, key.String())
+		}
+		params.Add("sort", strings.Join(keys, ","))
+	}
 }
 
 type SearchClient struct {
@@ -61,17 +183,47 @@ type SearchClient struct {
 	AccessToken string
 	// урл внешней системы, куда идти
 	URL string
+	// свой http клиент, если nil - общий client с таймаутом в секунду
+	HTTPClient *http.Client
+	// свой транспорт для клиента с тем же таймаутом, если HTTPClient не задан
+	Transport http.RoundTripper
+}
+
+func (srv *SearchClient) httpClient() *http.Client {
+	switch {
+	case srv.HTTPClient != nil:
+		return srv.HTTPClient
+	case srv.Transport != nil:
+		return &http.Client{Timeout: client.Timeout, Transport: srv.Transport}
+	}
+	return client
 }
 
 // FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
 func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
+
+// requestError отличает таймаут (клиента или дедлайн ctx) от отмены ctx, причина оборачивается
+func requestError(ctx context.Context, params url.Values, err error) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return fmt.Errorf("canceled for %s: %w", params.Encode(), ctx.Err())
+	}
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return &TimeoutError{Params: params.Encode(), Err: err}
+	}
+	return fmt.Errorf("unknown error %w", err)
+}
+
+// FindUsersContext - FindUsers с отменой и дедлайном через ctx
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
 	searcherParams := url.Values{}
 
 	if req.Limit < 0 {
 		return nil, fmt.Errorf("limit must be > 0")
 	}
-	if req.Limit > 25 {
-		req.Limit = 25
+	if req.Limit > maxLimit {
+		req.Limit = maxLimit
 	}
 	if req.Offset < 0 {
 		return nil, fmt.Errorf("offset must be > 0")
@@ -85,41 +237,56 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 	searcherParams.Add("query", req.Query)
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+	if req.QueryMode != "" {
+		searcherParams.Add("query_mode", req.QueryMode)
+	}
+	addFilters(searcherParams, req)
 
-	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
+	searcherReq, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"?"+searcherParams.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("bad request: %w", err)
+	}
 	searcherReq.Header.Add("AccessToken", srv.AccessToken)
 
-	resp, err := client.Do(searcherReq)
+	resp, err := srv.httpClient().Do(searcherReq)
 	if err != nil {
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
-		}
-		return nil, fmt.Errorf("unknown error %s", err)
+		return nil, requestError(ctx, searcherParams, err)
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, requestError(ctx, searcherParams, err)
+	}
 
 	switch resp.StatusCode {
+	case http.StatusOK:
 	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad AccessToken")
-	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
+		return nil, ErrUnauthorized
 	case http.StatusBadRequest:
 		errResp := SearchErrorResponse{}
 		err = json.Unmarshal(body, &errResp)
 		if err != nil {
-			return nil, fmt.Errorf("cant unpack error json: %s", err)
+			return nil, fmt.Errorf("cant unpack error json: %w", err)
 		}
-		if errResp.Error == "ErrorBadOrderField" {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+		switch errResp.Error {
+		case "ErrorBadOrderField":
+			field := req.OrderField
+			if errResp.Field != "" {
+				field = errResp.Field
+			}
+			return nil, &BadOrderFieldError{Field: field}
+		case "ErrorBadFilter":
+			return nil, &BadFilterError{Filter: errResp.Field}
 		}
-		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
+		return nil, &ServerError{Status: resp.StatusCode, Body: string(body)}
+	default:
+		return nil, &ServerError{Status: resp.StatusCode, Body: string(body)}
 	}
 
 	data := []User{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return nil, fmt.Errorf("cant unpack result json: %s", err)
+		return nil, fmt.Errorf("cant unpack result json: %w", err)
 	}
 
 	result := SearchResponse{}
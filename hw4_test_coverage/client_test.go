@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -159,6 +161,15 @@ func UnknownError(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, `{"Error": "Unknown error"}`)
 }
 
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	http.NotFound(w, r)
+}
+
+func Unavailable(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusServiceUnavailable)
+	io.WriteString(w, "try later")
+}
+
 func Unauthorized(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusUnauthorized)
 	io.WriteString(w, `{"Error": "Bad token"}`)
@@ -209,7 +220,7 @@ func Test_Limit(t *testing.T) {
 	req.Limit = 25
 	resp, err := client.FindUsers(*req)
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 		return
 	}
 
@@ -383,3 +394,187 @@ func Test_NilUrl(t *testing.T) {
 		t.Errorf("shoul pass error")
 	}
 }
+
+// Blocking отвечает, только когда клиент ушел, но не дольше 2 секунд
+func Blocking(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-r.Context().Done():
+	case <-time.After(2 * time.Second):
+	}
+}
+
+// roundTripFunc - транспорт без сети
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// brokenBody отдает ошибку при чтении тела ответа
+type brokenBody struct{}
+
+func (brokenBody) Read(p []byte) (int, error) {
+	return 0, errTest
+}
+
+func (brokenBody) Close() error {
+	return nil
+}
+
+func Test_ContextCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(Blocking))
+	defer ts.Close()
+	client := &SearchClient{}
+	client.URL = ts.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := client.FindUsersContext(ctx, SearchRequest{})
+
+	if !errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+	if strings.Contains(err.Error(), "timeout") {
+		t.Errorf("cancellation reported as timeout: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("request was not canceled in time")
+	}
+}
+
+func Test_ContextDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(Blocking))
+	defer ts.Close()
+	client := &SearchClient{}
+	client.URL = ts.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.FindUsersContext(ctx, SearchRequest{})
+
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		t.Errorf("expected deadline, got %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("deadline must be reported as timeout: %v", err)
+	}
+}
+
+func Test_CustomHTTPClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(Blocking))
+	defer ts.Close()
+	client := &SearchClient{}
+	client.URL = ts.URL
+	client.HTTPClient = &http.Client{Timeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	_, err := client.FindUsers(SearchRequest{})
+
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("custom client timeout was not used")
+	}
+}
+
+func Test_CustomTransport(t *testing.T) {
+	client := &SearchClient{}
+	client.URL = "http://search.local/"
+	client.AccessToken = "token"
+	client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Header.Get("AccessToken") != "token" || r.URL.Query().Get("limit") != "2" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`[{"Id": 7, "Name": "Boyd"}]`)),
+		}, nil
+	})
+
+	resp, err := client.FindUsers(SearchRequest{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 1 || resp.Users[0].Id != 7 || resp.NextPage {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func Test_BrokenBody(t *testing.T) {
+	client := &SearchClient{}
+	client.URL = "http://search.local/"
+	client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: brokenBody{}}, nil
+	})
+
+	_, err := client.FindUsers(SearchRequest{})
+	if !errors.Is(err, errTest) {
+		t.Errorf("expected body read error, got %v", err)
+	}
+}
+
+func Test_BadURL(t *testing.T) {
+	client := &SearchClient{}
+	client.URL = "http://[::1"
+
+	_, err := client.FindUsers(SearchRequest{})
+	if err == nil {
+		t.Errorf("shoul pass error")
+	}
+}
+
+func Test_TypedErrors(t *testing.T) {
+	cases := []struct {
+		handler http.HandlerFunc
+		message string
+		check   func(err error) bool
+	}{
+		{Unauthorized, "Bad AccessToken", func(err error) bool {
+			return errors.Is(err, ErrUnauthorized)
+		}},
+		{BadOrderField, "OrderField About invalid", func(err error) bool {
+			orderErr := &BadOrderFieldError{}
+			return errors.As(err, &orderErr) && orderErr.Field == "About"
+		}},
+		{FatalError, "SearchServer error 500", func(err error) bool {
+			serverErr := &ServerError{}
+			return errors.As(err, &serverErr) && serverErr.Status == http.StatusInternalServerError &&
+				strings.Contains(serverErr.Body, "StatusInternalServerError")
+		}},
+		{UnknownError, "SearchServer error 400", func(err error) bool {
+			serverErr := &ServerError{}
+			return errors.As(err, &serverErr) && serverErr.Status == http.StatusBadRequest && serverErr.Body == `{"Error": "Unknown error"}`
+		}},
+		{NotFound, "SearchServer error 404: 404 page not found", func(err error) bool {
+			serverErr := &ServerError{}
+			return errors.As(err, &serverErr) && serverErr.Status == http.StatusNotFound
+		}},
+		{Unavailable, "SearchServer error 503: try later", func(err error) bool {
+			serverErr := &ServerError{}
+			return errors.As(err, &serverErr) && serverErr.Status == http.StatusServiceUnavailable && serverErr.Body == "try later"
+		}},
+		{BadFormat, "cant unpack error json", func(err error) bool {
+			syntaxErr := &json.SyntaxError{}
+			return errors.As(err, &syntaxErr)
+		}},
+		{Blocking, "timeout for", func(err error) bool {
+			timeoutErr := &TimeoutError{}
+			return errors.As(err, &timeoutErr) && timeoutErr.Timeout() && errors.Is(err, context.DeadlineExceeded)
+		}},
+	}
+
+	for i, c := range cases {
+		ts := httptest.NewServer(c.handler)
+		client := &SearchClient{}
+		client.URL = ts.URL
+		client.HTTPClient = &http.Client{Timeout: 50 * time.Millisecond}
+
+		_, err := client.FindUsers(SearchRequest{OrderField: "About"})
+		if err == nil || !c.check(err) || !strings.Contains(err.Error(), c.message) {
+			t.Errorf("case %d: unexpected error %#v", i, err)
+		}
+		ts.Close()
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// параметры BM25
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// tokenize режет текст на слова в нижнем регистре. Слова внутри CamelCase разделяются,
+// поэтому Name вида BoydWolf находится и по boyd, и по wolf
+func tokenize(text string) []string {
+	tokens := []string{}
+	word := []rune{}
+	flush := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	prevLower := false
+	for _, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			prevLower = false
+			continue
+		}
+		if unicode.IsUpper(r) && prevLower {
+			flush()
+		}
+		word = append(word, r)
+		prevLower = unicode.IsLower(r)
+	}
+	flush()
+	return tokens
+}
+
+// fullTextIndex - обратный индекс по Name и About с позициями слов
+type fullTextIndex struct {
+	// слово -> номер пользователя -> позиции
+	postings map[string]map[int][]int
+	// все слова по порядку, для поиска по префиксу
+	terms  []string
+	docLen []int
+	avgLen float64
+}
+
+func newFullTextIndex(users []User) *fullTextIndex {
+	idx := &fullTextIndex{postings: map[string]map[int][]int{}, docLen: make([]int, len(users))}
+	total := 0
+	for doc, u := range users {
+		pos := 0
+		// между полями пропуск, чтобы фраза не склеивала конец Name и начало About
+		for _, field := range []string{u.Name, u.About} {
+			for _, term := range tokenize(field) {
+				if idx.postings[term] == nil {
+					idx.postings[term] = map[int][]int{}
+					idx.terms = append(idx.terms, term)
+				}
+				idx.postings[term][doc] = append(idx.postings[term][doc], pos)
+				pos++
+				idx.docLen[doc]++
+			}
+			pos++
+		}
+		total += idx.docLen[doc]
+	}
+	sort.Strings(idx.terms)
+	if len(users) > 0 {
+		idx.avgLen = float64(total) / float64(len(users))
+	}
+	return idx
+}
+
+// textClause - слово, слово с * на конце (префикс) или фраза в кавычках
+type textClause struct {
+	terms  []string
+	prefix bool
+}
+
+// parseTextQuery разбирает запрос: слова через пробел, "фраза в кавычках", префикс*.
+// Слово из нескольких токенов (foo-bar) ищется как фраза
+func parseTextQuery(query string) []textClause {
+	clauses := []textClause{}
+	for query != "" {
+		query = strings.TrimLeftFunc(query, unicode.IsSpace)
+		if query == "" {
+			break
+		}
+		text := ""
+		if query[0] == '"' {
+			end := strings.IndexByte(query[1:], '"')
+			if end < 0 {
+				text, query = query[1:], ""
+			} else {
+				text, query = query[1:end+1], query[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(query, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+			if end < 0 {
+				end = len(query)
+			}
+			text, query = query[:end], query[end:]
+		}
+
+		clause := textClause{terms: tokenize(text), prefix: strings.HasSuffix(text, "*")}
+		if len(clause.terms) == 0 {
+			continue
+		}
+		clause.prefix = clause.prefix && len(clause.terms) == 1
+		clauses = append(clauses, clause)
+	}
+	return clauses
+}
+
+// frequencies - сколько раз условие встречается у каждого пользователя
+func (idx *fullTextIndex) frequencies(c textClause) map[int]int {
+	freqs := map[int]int{}
+	switch {
+	case c.prefix:
+		start := sort.SearchStrings(idx.terms, c.terms[0])
+		for _, term := range idx.terms[start:] {
+			if !strings.HasPrefix(term, c.terms[0]) {
+				break
+			}
+			for doc, positions := range idx.postings[term] {
+				freqs[doc] += len(positions)
+			}
+		}
+	case len(c.terms) == 1:
+		for doc, positions := range idx.postings[c.terms[0]] {
+			freqs[doc] = len(positions)
+		}
+	default:
+		for doc, positions := range idx.postings[c.terms[0]] {
+			for _, pos := range positions {
+				if idx.phraseAt(doc, pos, c.terms[1:]) {
+					freqs[doc]++
+				}
+			}
+		}
+	}
+	return freqs
+}
+
+// phraseAt проверяет, что terms идут у doc сразу за позицией pos
+func (idx *fullTextIndex) phraseAt(doc, pos int, terms []string) bool {
+	for i, term := range terms {
+		positions := idx.postings[term][doc]
+		k := sort.SearchInts(positions, pos+i+1)
+		if k == len(positions) || positions[k] != pos+i+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Search возвращает BM25 оценки пользователей, у которых нашлись все условия.
+// Без условий подходят все с нулевой оценкой
+func (idx *fullTextIndex) Search(clauses []textClause) map[int]float64 {
+	scores := map[int]float64{}
+	if len(clauses) == 0 {
+		for doc := range idx.docLen {
+			scores[doc] = 0
+		}
+		return scores
+	}
+
+	n := float64(len(idx.docLen))
+	for i, c := range clauses {
+		freqs := idx.frequencies(c)
+		df := float64(len(freqs))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		next := map[int]float64{}
+		for doc, tf := range freqs {
+			score, ok := scores[doc]
+			if i > 0 && !ok {
+				continue
+			}
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(idx.docLen[doc])/idx.avgLen)
+			next[doc] = score + idf*float64(tf)*(bm25K1+1)/(float64(tf)+norm)
+		}
+		scores = next
+	}
+	return scores
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func Test_Tokenize(t *testing.T) {
+	got := tokenize("BoydWolf, nulla-cillum 42x  Ünïcode")
+	expected := []string{"boyd", "wolf", "nulla", "cillum", "42x", "ünïcode"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func Test_ParseTextQuery(t *testing.T) {
+	got := parseTextQuery(` Cillum "ut  Nulla" pari* foo-bar*  !!! "unterminated phrase`)
+	expected := []textClause{
+		{terms: []string{"cillum"}},
+		{terms: []string{"ut", "nulla"}},
+		{terms: []string{"pari"}, prefix: true},
+		{terms: []string{"foo", "bar"}},
+		{terms: []string{"unterminated", "phrase"}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+}
+
+func Test_FullTextIndex(t *testing.T) {
+	idx := newFullTextIndex([]User{
+		{Name: "AnnLee", About: "red apple red apple"},
+		{Name: "BobRay", About: "red car"},
+		{Name: "CarlDoe", About: "green apple pie apple"},
+	})
+
+	// найденные по убыванию оценки
+	ranked := func(query string) []int {
+		scores := idx.Search(parseTextQuery(query))
+		docs := []int{}
+		for doc := range scores {
+			docs = append(docs, doc)
+		}
+		sort.Slice(docs, func(i, j int) bool {
+			if scores[docs[i]] != scores[docs[j]] {
+				return scores[docs[i]] > scores[docs[j]]
+			}
+			return docs[i] < docs[j]
+		})
+		return docs
+	}
+	cases := map[string][]int{
+		"red":             {0, 1},
+		"RED apple":       {0},
+		`"apple pie"`:     {2},
+		`"red apple"`:     {0},
+		`"ray red"`:       {},
+		"app*":            {0, 2},
+		"ca*":             {1, 2},
+		"red app*":        {0},
+		"carl":            {2},
+		"nothing":         {},
+		`"apple nothing"`: {},
+	}
+	for query, expected := range cases {
+		if got := ranked(query); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: got %v, expected %v", query, got, expected)
+		}
+	}
+
+	all := idx.Search(nil)
+	if len(all) != 3 || all[0] != 0 || all[2] != 0 {
+		t.Errorf("empty query must match everyone with zero score: %v", all)
+	}
+	// у редкого слова вес больше, чем у частого
+	scores := idx.Search(parseTextQuery("car"))
+	if red := idx.Search(parseTextQuery("red")); scores[1] <= red[1] {
+		t.Errorf("rare term scored %v, frequent %v", scores[1], red[1])
+	}
+}
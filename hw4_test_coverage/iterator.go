@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+)
+
+// UserIterator обходит все страницы FindUsers. Следующая страница запрашивается в фоне,
+// пока читается текущая. Обход кончается на последней странице, на ошибке или на Max
+//
+//	it := srv.Users(ctx, req)
+//	defer it.Close()
+//	for it.Next() {
+//		u := it.User()
+//	}
+//	if err := it.Err(); err != nil {
+type UserIterator struct {
+	// сколько пользователей отдать всего, 0 - без ограничения. Менять до первого Next
+	Max int
+
+	srv    *SearchClient
+	req    SearchRequest
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	pages  chan pageResult
+
+	page  []User
+	pos   int
+	count int
+	user  User
+	err   error
+	done  bool
+}
+
+type pageResult struct {
+	resp *SearchResponse
+	err  error
+}
+
+// Users возвращает итератор по всем найденным пользователям начиная с req.Offset.
+// req.Limit - размер страницы, 0 - максимальный
+func (srv *SearchClient) Users(ctx context.Context, req SearchRequest) *UserIterator {
+	if req.Limit == 0 || req.Limit > maxLimit {
+		req.Limit = maxLimit
+	}
+	return &UserIterator{srv: srv, req: req, parent: ctx}
+}
+
+// fetch запрашивает страницы по очереди, пока итератор не закроют
+func (it *UserIterator) fetch(req SearchRequest) {
+	defer close(it.pages)
+	start := req.Offset
+	for {
+		resp, err := it.srv.FindUsersContext(it.ctx, req)
+		select {
+		case it.pages <- pageResult{resp, err}:
+		case <-it.ctx.Done():
+			return
+		}
+		if err != nil || !resp.NextPage || len(resp.Users) == 0 {
+			return
+		}
+		req.Offset += len(resp.Users)
+		if it.Max > 0 && req.Offset-start >= it.Max {
+			return
+		}
+	}
+}
+
+// Next переходит к следующему пользователю, false - пользователи кончились или ошибка
+func (it *UserIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	if it.pages == nil {
+		it.ctx, it.cancel = context.WithCancel(it.parent)
+		it.pages = make(chan pageResult)
+		go it.fetch(it.req)
+	}
+	if it.Max > 0 && it.count >= it.Max {
+		it.Close()
+		return false
+	}
+	if err := it.parent.Err(); err != nil {
+		it.err = err
+		it.Close()
+		return false
+	}
+
+	for it.pos >= len(it.page) {
+		result, ok := <-it.pages
+		if !ok {
+			// страницу могли не отдать из-за отмены снаружи
+			it.err = it.parent.Err()
+			it.Close()
+			return false
+		}
+		if result.err != nil {
+			it.err = result.err
+			it.Close()
+			return false
+		}
+		it.page, it.pos = result.resp.Users, 0
+	}
+
+	it.user = it.page[it.pos]
+	it.pos++
+	it.count++
+	return true
+}
+
+// User - текущий пользователь после успешного Next
+func (it *UserIterator) User() User {
+	return it.user
+}
+
+// Err - ошибка, на которой остановился обход
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
+// Close останавливает фоновые запросы, нужен, если обход бросили раньше конца
+func (it *UserIterator) Close() {
+	it.done = true
+	if it.cancel != nil {
+		it.cancel()
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingServer - SearchServer, который считает запросы и может отвечать ошибкой на запрос fail
+func countingServer(requests *int32, fail int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) == fail {
+			FatalError(w, r)
+			return
+		}
+		SearchServer(w, r)
+	}))
+}
+
+// SearchServer сортирует уже после offset, поэтому обход страницами без повторов - только по Id
+var pagedByID = SearchRequest{Limit: 10, OrderField: "Id"}
+
+// collect забирает всех пользователей итератора
+func collect(it *UserIterator) []User {
+	result := []User{}
+	for it.Next() {
+		result = append(result, it.User())
+	}
+	return result
+}
+
+func Test_Iterator(t *testing.T) {
+	requests := int32(0)
+	ts := countingServer(&requests, 0)
+	defer ts.Close()
+	client := &SearchClient{}
+	client.URL = ts.URL
+
+	it := client.Users(context.Background(), pagedByID)
+	result := collect(it)
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if len(result) != 35 {
+		t.Errorf("expected all 35 users, got %d", len(result))
+	}
+	seen := map[int]bool{}
+	for _, u := range result {
+		if seen[u.Id] {
+			t.Errorf("user %d returned twice", u.Id)
+		}
+		seen[u.Id] = true
+	}
+	if requests != 4 {
+		t.Errorf("expected 4 pages, got %d requests", requests)
+	}
+	if it.Next() {
+		t.Errorf("finished iterator must stay finished")
+	}
+
+	// размер страницы по умолчанию - максимальный
+	requests = 0
+	if result := collect(client.Users(context.Background(), SearchRequest{Offset: 30, OrderField: "Id"})); len(result) != 5 || requests != 1 {
+		t.Errorf("got %d users in %d requests from offset 30", len(result), requests)
+	}
+}
+
+func Test_IteratorMax(t *testing.T) {
+	requests := int32(0)
+	ts := countingServer(&requests, 0)
+	defer ts.Close()
+	client := &SearchClient{}
+	client.URL = ts.URL
+
+	for _, max := range []int{1, 10, 12, 30, 100} {
+		requests = 0
+		it := client.Users(context.Background(), pagedByID)
+		it.Max = max
+		result := collect(it)
+
+		expected := max
+		if expected > 35 {
+			expected = 35
+		}
+		if len(result) != expected || it.Err() != nil {
+			t.Errorf("max %d: got %d users, %v", max, len(result), it.Err())
+		}
+		// лишней может быть только одна заранее запрошенная страница
+		if pages := int32((expected+9)/10 + 1); atomic.LoadInt32(&requests) > pages {
+			t.Errorf("max %d: %d requests", max, requests)
+		}
+	}
+}
+
+func Test_IteratorError(t *testing.T) {
+	requests := int32(0)
+	ts := countingServer(&requests, 2)
+	defer ts.Close()
+	client := &SearchClient{}
+	client.URL = ts.URL
+
+	it := client.Users(context.Background(), pagedByID)
+	result := collect(it)
+	serverErr := &ServerError{}
+	if !errors.As(it.Err(), &serverErr) {
+		t.Errorf("expected server error, got %v", it.Err())
+	}
+	if len(result) != 10 {
+		t.Errorf("expected first page only, got %d users", len(result))
+	}
+
+	it = client.Users(context.Background(), SearchRequest{Limit: -1})
+	if it.Next() || it.Err() == nil {
+		t.Errorf("negative limit must fail")
+	}
+}
+
+func Test_IteratorPrefetch(t *testing.T) {
+	requested := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- r.URL.Query().Get("offset")
+		SearchServer(w, r)
+	}))
+	defer ts.Close()
+	client := &SearchClient{}
+	client.URL = ts.URL
+
+	it := client.Users(context.Background(), pagedByID)
+	defer it.Close()
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	for _, offset := range []string{"0", "10"} {
+		select {
+		case got := <-requested:
+			if got != offset {
+				t.Errorf("expected offset %s, got %s", offset, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("page at offset %s was not prefetched", offset)
+		}
+	}
+	// дальше одной страницы вперед не запрашивает
+	select {
+	case got := <-requested:
+		t.Errorf("unexpected request at offset %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func Test_IteratorCancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+	client := &SearchClient{}
+	client.URL = ts.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it := client.Users(ctx, SearchRequest{Limit: 5})
+	n := 0
+	for it.Next() {
+		n++
+		if n == 7 {
+			cancel()
+		}
+	}
+	if !errors.Is(it.Err(), context.Canceled) {
+		t.Errorf("expected cancellation, got %v", it.Err())
+	}
+	if n != 7 {
+		t.Errorf("iteration went on after cancel: %d users", n)
+	}
+}
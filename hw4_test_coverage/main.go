@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// searchserver: SearchService над dataset.xml, AccessToken сверяется с файлом токенов
+func main() {
+	addr := flag.String("addr", ":8080", "где слушать")
+	datasetPath := flag.String("dataset", "dataset.xml", "файл с пользователями")
+	tokensPath := flag.String("tokens", "tokens.txt", "файл с допустимыми AccessToken, по одному в строке")
+	flag.Parse()
+
+	service, err := openSearchService(*datasetPath, *tokensPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d users, listening on %s", len(service.users), *addr)
+	log.Fatal(http.ListenAndServe(*addr, service))
+}
+
+func openSearchService(datasetPath, tokensPath string) (*SearchService, error) {
+	datasetFile, err := os.Open(datasetPath)
+	if err != nil {
+		return nil, err
+	}
+	defer datasetFile.Close()
+	users, err := LoadUsers(datasetFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tokensFile, err := os.Open(tokensPath)
+	if err != nil {
+		return nil, err
+	}
+	defer tokensFile.Close()
+	tokens, err := LoadTokens(tokensFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens in %s", tokensPath)
+	}
+	return NewSearchService(users, tokens), nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// коды ошибок в SearchErrorResponse, ErrorBadOrderField клиент разбирает отдельно
+const (
+	errorBadOrderField = "ErrorBadOrderField"
+	errorBadOrderBy    = "ErrorBadOrderBy"
+	errorBadLimit      = "ErrorBadLimit"
+	errorBadOffset     = "ErrorBadOffset"
+	errorBadQueryMode  = "ErrorBadQueryMode"
+	errorBadFilter     = "ErrorBadFilter"
+	errorBadToken      = "Bad AccessToken"
+)
+
+type datasetRow struct {
+	Id        int    `xml:"id"`
+	FirstName string `xml:"first_name"`
+	LastName  string `xml:"last_name"`
+	Age       int    `xml:"age"`
+	About     string `xml:"about"`
+	Gender    string `xml:"gender"`
+}
+
+type dataset struct {
+	Rows []datasetRow `xml:"row"`
+}
+
+// LoadUsers читает пользователей из dataset.xml, Name - first_name + last_name
+func LoadUsers(r io.Reader) ([]User, error) {
+	data := dataset{}
+	if err := xml.NewDecoder(r).Decode(&data); err != nil {
+		return nil, err
+	}
+	users := make([]User, 0, len(data.Rows))
+	for _, row := range data.Rows {
+		users = append(users, User{
+			Id:     row.Id,
+			Name:   row.FirstName + row.LastName,
+			Age:    row.Age,
+			About:  row.About,
+			Gender: row.Gender,
+		})
+	}
+	return users, nil
+}
+
+// LoadTokens читает допустимые AccessToken, по одному в строке. Пустые строки и # пропускаются
+func LoadTokens(r io.Reader) (map[string]bool, error) {
+	tokens := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		token := strings.TrimSpace(scanner.Text())
+		if token != "" && !strings.HasPrefix(token, "#") {
+			tokens[token] = true
+		}
+	}
+	return tokens, scanner.Err()
+}
+
+// SearchService - внешняя система из hw4.md: ищет по пользователям из dataset.xml
+type SearchService struct {
+	users  []User
+	tokens map[string]bool
+	index  *fullTextIndex
+}
+
+func NewSearchService(users []User, tokens map[string]bool) *SearchService {
+	return &SearchService{users: users, tokens: tokens, index: newFullTextIndex(users)}
+}
+
+func writeSearchError(w http.ResponseWriter, status int, resp *SearchErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// intValue читает неотрицательный параметр, пустой - 0
+func intValue(params url.Values, name string) (int, bool) {
+	value := params.Get(name)
+	if value == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(value)
+	return n, err == nil && n >= 0
+}
+
+// parseAge разбирает age=20-30, 20-, -30 или 25
+func parseAge(value string, req *SearchRequest) bool {
+	min, max := value, value
+	if i := strings.IndexByte(value, '-'); i >= 0 {
+		min, max = value[:i], value[i+1:]
+	}
+	if min == "" && max == "" {
+		return false
+	}
+	ok := true
+	for _, bound := range []struct {
+		text  string
+		value *int
+	}{{min, &req.MinAge}, {max, &req.MaxAge}} {
+		if bound.text == "" {
+			continue
+		}
+		n, err := strconv.Atoi(bound.text)
+		ok = ok && err == nil && n >= 0
+		*bound.value = n
+	}
+	return ok && (req.MaxAge == 0 || req.MinAge <= req.MaxAge)
+}
+
+// parseFilters разбирает age, gender и id, вторым возвращает плохой параметр
+func parseFilters(params url.Values, req *SearchRequest) string {
+	if age := params.Get("age"); age != "" && !parseAge(age, req) {
+		return "age"
+	}
+	req.Gender = params.Get("gender")
+	if req.Gender != "" && req.Gender != "male" && req.Gender != "female" {
+		return "gender"
+	}
+	if ids := params.Get("id"); ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(id))
+			if err != nil || n < 0 {
+				return "id"
+			}
+			req.Ids = append(req.Ids, n)
+		}
+	}
+	return ""
+}
+
+var sortFields = map[string]bool{"Id": true, "Age": true, "Name": true, "Gender": true, OrderFieldRelevance: true}
+
+// parseSort разбирает sort=Age desc,Name asc. Без направления - asc, у Relevance - desc
+func parseSort(value string) ([]SortKey, *SearchErrorResponse) {
+	keys := []SortKey{}
+	for _, part := range strings.Split(value, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 || len(words) > 2 || !sortFields[words[0]] {
+			return nil, &SearchErrorResponse{Error: errorBadOrderField, Field: strings.TrimSpace(part)}
+		}
+		key := SortKey{Field: words[0], Desc: words[0] == OrderFieldRelevance}
+		if len(words) == 2 {
+			switch strings.ToLower(words[1]) {
+			case "asc":
+				key.Desc = false
+			case "desc":
+				key.Desc = true
+			default:
+				return nil, &SearchErrorResponse{Error: errorBadOrderBy, Field: words[0]}
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseSearchRequest разбирает параметры запроса, ошибка уходит клиенту как есть
+func parseSearchRequest(params url.Values) (SearchRequest, *SearchErrorResponse) {
+	req := SearchRequest{
+		Query:      params.Get("query"),
+		OrderField: params.Get("order_field"),
+	}
+	ok := false
+	if req.Limit, ok = intValue(params, "limit"); !ok {
+		return req, &SearchErrorResponse{Error: errorBadLimit}
+	}
+	if req.Offset, ok = intValue(params, "offset"); !ok {
+		return req, &SearchErrorResponse{Error: errorBadOffset}
+	}
+	if filter := parseFilters(params, &req); filter != "" {
+		return req, &SearchErrorResponse{Error: errorBadFilter, Field: filter}
+	}
+
+	relevance := req.OrderField == OrderFieldRelevance
+	if sort := params.Get("sort"); sort != "" {
+		var errResp *SearchErrorResponse
+		if req.Sort, errResp = parseSort(sort); errResp != nil {
+			return req, errResp
+		}
+		for _, key := range req.Sort {
+			relevance = relevance || key.Field == OrderFieldRelevance
+		}
+	}
+
+	req.QueryMode = params.Get("query_mode")
+	if req.QueryMode == "" {
+		req.QueryMode = QueryModeSubstring
+		if relevance {
+			req.QueryMode = QueryModeFullText
+		}
+	}
+	if req.QueryMode != QueryModeSubstring && req.QueryMode != QueryModeFullText {
+		return req, &SearchErrorResponse{Error: errorBadQueryMode}
+	}
+	// в режиме подстроки оценок нет
+	if relevance && req.QueryMode != QueryModeFullText {
+		return req, &SearchErrorResponse{Error: errorBadOrderField, Field: OrderFieldRelevance}
+	}
+
+	// пустой order_field - по Name, даже при order_by как встретилось
+	byName := req.OrderField == ""
+	if byName {
+		req.OrderField = "Name"
+	}
+	if req.OrderField != "Id" && req.OrderField != "Age" && req.OrderField != "Name" && req.OrderField != OrderFieldRelevance {
+		return req, &SearchErrorResponse{Error: errorBadOrderField}
+	}
+
+	orderBy := params.Get("order_by")
+	if orderBy == "" {
+		orderBy = "0"
+	}
+	n, err := strconv.Atoi(orderBy)
+	if err != nil || n < OrderByAsc || n > OrderByDesc {
+		return req, &SearchErrorResponse{Error: errorBadOrderBy}
+	}
+	req.OrderBy = n
+	if byName && n == OrderByAsIs {
+		req.OrderBy = OrderByAsc
+	}
+	return req, nil
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareUsers сравнивает пользователей по полю сортировки, кроме Relevance
+func compareUsers(field string, a, b *User) int {
+	switch field {
+	case "Id":
+		return compareInts(a.Id, b.Id)
+	case "Age":
+		return compareInts(a.Age, b.Age)
+	case "Gender":
+		return strings.Compare(a.Gender, b.Gender)
+	}
+	return strings.Compare(a.Name, b.Name)
+}
+
+// sortKeys - ключи сортировки запроса: Sort или OrderField с OrderBy.
+// По Relevance лучшие идут первыми и при OrderByAsIs, OrderByAsc их переворачивает
+func sortKeys(req SearchRequest) []SortKey {
+	switch {
+	case len(req.Sort) > 0:
+		return req.Sort
+	case req.OrderField == OrderFieldRelevance:
+		return []SortKey{{Field: OrderFieldRelevance, Desc: req.OrderBy != OrderByAsc}}
+	case req.OrderBy != OrderByAsIs:
+		return []SortKey{{Field: req.OrderField, Desc: req.OrderBy == OrderByDesc}}
+	}
+	return nil
+}
+
+// filtered проверяет фильтры запроса
+func filtered(req *SearchRequest, ids map[int]bool, u *User) bool {
+	return (req.MinAge == 0 || u.Age >= req.MinAge) &&
+		(req.MaxAge == 0 || u.Age <= req.MaxAge) &&
+		(req.Gender == "" || u.Gender == req.Gender) &&
+		(len(ids) == 0 || ids[u.Id])
+}
+
+// Search фильтрует, ищет query подстрокой в Name и About или по полнотекстовому индексу,
+// сортирует и только потом отрезает offset и limit. limit 0 - без ограничения
+func (s *SearchService) Search(req SearchRequest) []User {
+	ids := map[int]bool{}
+	for _, id := range req.Ids {
+		ids[id] = true
+	}
+
+	found := []int{}
+	var scores map[int]float64
+	if req.QueryMode == QueryModeFullText {
+		scores = s.index.Search(parseTextQuery(req.Query))
+	}
+	for i := range s.users {
+		u := &s.users[i]
+		if !filtered(&req, ids, u) {
+			continue
+		}
+		if req.QueryMode == QueryModeFullText {
+			if _, ok := scores[i]; !ok {
+				continue
+			}
+		} else if req.Query != "" && !strings.Contains(u.Name, req.Query) && !strings.Contains(u.About, req.Query) {
+			continue
+		}
+		found = append(found, i)
+	}
+
+	if keys := sortKeys(req); len(keys) > 0 {
+		sort.SliceStable(found, func(i, j int) bool {
+			for _, key := range keys {
+				c := 0
+				if key.Field == OrderFieldRelevance {
+					c = compareFloats(scores[found[i]], scores[found[j]])
+				} else {
+					c = compareUsers(key.Field, &s.users[found[i]], &s.users[found[j]])
+				}
+				if key.Desc {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+
+	if req.Offset >= len(found) {
+		return []User{}
+	}
+	found = found[req.Offset:]
+	if req.Limit > 0 && req.Limit < len(found) {
+		found = found[:req.Limit]
+	}
+	result := make([]User, 0, len(found))
+	for _, i := range found {
+		result = append(result, s.users[i])
+	}
+	return result
+}
+
+func (s *SearchService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.tokens[r.Header.Get("AccessToken")] {
+		writeSearchError(w, http.StatusUnauthorized, &SearchErrorResponse{Error: errorBadToken})
+		return
+	}
+	req, errResp := parseSearchRequest(r.URL.Query())
+	if errResp != nil {
+		writeSearchError(w, http.StatusBadRequest, errResp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Search(req))
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testToken = "secret"
+
+// startSearchService поднимает SearchService так же, как main, и клиент к нему
+func startSearchService(t *testing.T) (*httptest.Server, *SearchClient) {
+	tokensPath := filepath.Join(t.TempDir(), "tokens.txt")
+	if err := os.WriteFile(tokensPath, []byte("# tokens\n\n"+testToken+"\n  other  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	service, err := openSearchService("dataset.xml", tokensPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(service)
+	return ts, &SearchClient{AccessToken: testToken, URL: ts.URL}
+}
+
+func Test_ServiceSearch(t *testing.T) {
+	ts, client := startSearchService(t)
+	defer ts.Close()
+
+	resp, err := client.FindUsers(SearchRequest{Limit: 3, OrderField: "Id", OrderBy: OrderByDesc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 3 || resp.Users[0].Id != 34 || resp.Users[2].Id != 32 || !resp.NextPage {
+		t.Errorf("unexpected Id desc page %+v", resp)
+	}
+
+	resp, err = client.FindUsers(SearchRequest{Limit: 25, OrderField: "Age", OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sort.SliceIsSorted(resp.Users, func(i, j int) bool { return resp.Users[i].Age < resp.Users[j].Age }) {
+		t.Errorf("users are not sorted by Age")
+	}
+
+	// пустой order_field - по Name, offset после сортировки
+	resp, err = client.FindUsers(SearchRequest{Limit: 2, Offset: 1, OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, _ := client.FindUsers(SearchRequest{Limit: 3, OrderBy: OrderByAsc})
+	if len(resp.Users) != 2 || resp.Users[0] != all.Users[1] || resp.Users[1] != all.Users[2] {
+		t.Errorf("offset must apply after sorting: %+v vs %+v", resp.Users, all.Users)
+	}
+
+	resp, err = client.FindUsers(SearchRequest{Limit: 25, Query: "Boyd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) == 0 || resp.NextPage {
+		t.Errorf("expected a few users for Boyd, got %+v", resp)
+	}
+	for _, u := range resp.Users {
+		if !strings.Contains(u.Name, "Boyd") && !strings.Contains(u.About, "Boyd") {
+			t.Errorf("user %d does not match query", u.Id)
+		}
+	}
+	if resp.Users[0].Name != "BoydWolf" || resp.Users[0].Gender != "male" || resp.Users[0].Age != 22 {
+		t.Errorf("unexpected first user %+v", resp.Users[0])
+	}
+
+	resp, err = client.FindUsers(SearchRequest{Offset: 100})
+	if err != nil || len(resp.Users) != 0 || resp.NextPage {
+		t.Errorf("expected empty page after the end, got %+v, %v", resp, err)
+	}
+
+	// без параметров - все по Name
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("AccessToken", testToken)
+	raw, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Body.Close()
+	users := []User{}
+	if err := json.NewDecoder(raw.Body).Decode(&users); err != nil || len(users) != 35 {
+		t.Errorf("expected all users, got %d, %v", len(users), err)
+	}
+	if !sort.SliceIsSorted(users, func(i, j int) bool { return users[i].Name < users[j].Name }) {
+		t.Errorf("users without order_field must be sorted by Name")
+	}
+
+	// order_by как встретилось - только для явного order_field
+	resp, err = client.FindUsers(SearchRequest{Limit: 25})
+	if err != nil || !sort.SliceIsSorted(resp.Users, func(i, j int) bool { return resp.Users[i].Name < resp.Users[j].Name }) {
+		t.Errorf("empty order_field must sort by Name: %+v, %v", resp, err)
+	}
+	resp, err = client.FindUsers(SearchRequest{Limit: 3, OrderField: "Age"})
+	if err != nil || resp.Users[0].Id != 0 || resp.Users[2].Id != 2 {
+		t.Errorf("explicit order_field with OrderByAsIs must keep dataset order: %+v, %v", resp, err)
+	}
+}
+
+func Test_ServiceIterator(t *testing.T) {
+	ts, client := startSearchService(t)
+	defer ts.Close()
+
+	it := client.Users(context.Background(), SearchRequest{Limit: 4, OrderBy: OrderByDesc})
+	names := []string{}
+	for it.Next() {
+		names = append(names, it.User().Name)
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if len(names) != 35 || !sort.SliceIsSorted(names, func(i, j int) bool { return names[i] > names[j] }) {
+		t.Errorf("expected 35 users by Name desc, got %v", names)
+	}
+}
+
+func Test_ServiceErrors(t *testing.T) {
+	ts, client := startSearchService(t)
+	defer ts.Close()
+
+	bad := &SearchClient{AccessToken: "wrong", URL: ts.URL}
+	if _, err := bad.FindUsers(SearchRequest{}); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+
+	_, err := client.FindUsers(SearchRequest{OrderField: "About"})
+	orderErr := &BadOrderFieldError{}
+	if !errors.As(err, &orderErr) || orderErr.Field != "About" {
+		t.Errorf("expected BadOrderFieldError, got %v", err)
+	}
+
+	cases := map[string]string{
+		"limit=x":      errorBadLimit,
+		"limit=-1":     errorBadLimit,
+		"offset=-5":    errorBadOffset,
+		"order_by=2":   errorBadOrderBy,
+		"order_by=asc": errorBadOrderBy,
+		"age=x-30":     errorBadFilter,
+		"age=40-30":    errorBadFilter,
+		"age=-":        errorBadFilter,
+		"gender=other": errorBadFilter,
+		"id=1,,2":      errorBadFilter,
+		"sort=Age+up":  errorBadOrderBy,
+		"sort=About":   errorBadOrderField,
+		"sort=Age,":    errorBadOrderField,
+	}
+	for query, code := range cases {
+		req, _ := http.NewRequest("GET", ts.URL+"?"+query, nil)
+		req.Header.Set("AccessToken", testToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errResp := SearchErrorResponse{}
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || errResp.Error != code {
+			t.Errorf("%s: got %d %q, expected %s", query, resp.StatusCode, errResp.Error, code)
+		}
+	}
+}
+
+func Test_OpenSearchService(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.txt")
+	broken := filepath.Join(dir, "broken.xml")
+	os.WriteFile(empty, []byte("# nobody\n"), 0644)
+	os.WriteFile(broken, []byte("<root><row>"), 0644)
+
+	paths := [][2]string{
+		{filepath.Join(dir, "missing.xml"), empty},
+		{broken, empty},
+		{"dataset.xml", filepath.Join(dir, "missing.txt")},
+		{"dataset.xml", empty},
+	}
+	for _, p := range paths {
+		if _, err := openSearchService(p[0], p[1]); err == nil {
+			t.Errorf("%v: expected error", p)
+		}
+	}
+}
+
+func Test_ServiceFullText(t *testing.T) {
+	ts, client := startSearchService(t)
+	defer ts.Close()
+
+	resp, err := client.FindUsers(SearchRequest{Limit: 5, Query: "boyd", OrderField: OrderFieldRelevance})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) == 0 || resp.Users[0].Name != "BoydWolf" {
+		t.Errorf("expected BoydWolf first, got %+v", resp.Users)
+	}
+
+	// подстрокой "boyd" в нижнем регистре не находится, словом - находится
+	resp, err = client.FindUsers(SearchRequest{Limit: 25, Query: "boyd"})
+	if err != nil || len(resp.Users) != 0 {
+		t.Errorf("substring mode must stay case sensitive: %+v, %v", resp, err)
+	}
+
+	resp, err = client.FindUsers(SearchRequest{Limit: 25, Query: `"ea sit" cill*`, QueryMode: QueryModeFullText, OrderField: "Id", OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) == 0 {
+		t.Errorf("expected users for phrase and prefix")
+	}
+	for i, u := range resp.Users {
+		if !strings.Contains(strings.ToLower(u.About), "ea sit") || !strings.Contains(strings.ToLower(u.About), "cill") {
+			t.Errorf("user %d does not match", u.Id)
+		}
+		if i > 0 && resp.Users[i-1].Id > u.Id {
+			t.Errorf("users are not sorted by Id")
+		}
+	}
+
+	worst, err := client.FindUsers(SearchRequest{Limit: 25, Query: "boyd", OrderField: OrderFieldRelevance, OrderBy: OrderByAsc})
+	if err != nil || worst.Users[len(worst.Users)-1].Name != "BoydWolf" {
+		t.Errorf("OrderByAsc must put the best match last: %+v, %v", worst, err)
+	}
+
+	_, err = client.FindUsers(SearchRequest{Query: "boyd", OrderField: OrderFieldRelevance, QueryMode: QueryModeSubstring})
+	orderErr := &BadOrderFieldError{}
+	if !errors.As(err, &orderErr) {
+		t.Errorf("Relevance in substring mode must fail, got %v", err)
+	}
+	_, err = client.FindUsers(SearchRequest{QueryMode: "regexp"})
+	serverErr := &ServerError{}
+	if !errors.As(err, &serverErr) || !strings.Contains(serverErr.Body, errorBadQueryMode) {
+		t.Errorf("expected %s, got %v", errorBadQueryMode, err)
+	}
+}
+
+func Test_ServiceFilters(t *testing.T) {
+	ts, client := startSearchService(t)
+	defer ts.Close()
+
+	resp, err := client.FindUsers(SearchRequest{Limit: 25, MinAge: 30, MaxAge: 35, Gender: "female", Sort: []SortKey{{"Age", true}, {"Name", false}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) == 0 {
+		t.Fatal("expected female users aged 30-35")
+	}
+	for i, u := range resp.Users {
+		if u.Age < 30 || u.Age > 35 || u.Gender != "female" {
+			t.Errorf("user %d does not match filters: %+v", u.Id, u)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := resp.Users[i-1]
+		if prev.Age < u.Age || prev.Age == u.Age && prev.Name > u.Name {
+			t.Errorf("users are not sorted by Age desc, Name asc: %+v, %+v", prev, u)
+		}
+	}
+
+	resp, err = client.FindUsers(SearchRequest{Limit: 25, Ids: []int{5, 1, 20}, Sort: []SortKey{{Field: "Id"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 3 || resp.Users[0].Id != 1 || resp.Users[1].Id != 5 || resp.Users[2].Id != 20 {
+		t.Errorf("expected users 1, 5, 20, got %+v", resp.Users)
+	}
+
+	// границы возраста по отдельности
+	for _, req := range []SearchRequest{{Limit: 25, MinAge: 38}, {Limit: 25, MaxAge: 21}} {
+		resp, err = client.FindUsers(req)
+		if err != nil || len(resp.Users) == 0 {
+			t.Fatalf("%+v: %+v, %v", req, resp, err)
+		}
+		for _, u := range resp.Users {
+			if req.MinAge != 0 && u.Age < req.MinAge || req.MaxAge != 0 && u.Age > req.MaxAge {
+				t.Errorf("%+v: user %d aged %d", req, u.Id, u.Age)
+			}
+		}
+	}
+
+	// Relevance в sort включает полнотекстовый поиск, лучшие первыми
+	resp, err = client.FindUsers(SearchRequest{Limit: 5, Query: "boyd", Sort: []SortKey{{Field: OrderFieldRelevance, Desc: true}, {Field: "Id"}}})
+	if err != nil || len(resp.Users) == 0 || resp.Users[0].Name != "BoydWolf" {
+		t.Errorf("expected BoydWolf first by Relevance, got %+v, %v", resp, err)
+	}
+
+	// сначала по Gender, внутри - лучшие первыми
+	resp, err = client.FindUsers(SearchRequest{Limit: 25, Query: "cill*", Sort: []SortKey{{Field: "Gender"}, {Field: OrderFieldRelevance}}})
+	if err != nil || len(resp.Users) < 2 {
+		t.Fatalf("expected users for cill*, got %+v, %v", resp, err)
+	}
+	if !sort.SliceIsSorted(resp.Users, func(i, j int) bool { return resp.Users[i].Gender < resp.Users[j].Gender }) {
+		t.Errorf("users are not sorted by Gender")
+	}
+
+	_, err = client.FindUsers(SearchRequest{Gender: "unknown"})
+	filterErr := &BadFilterError{}
+	if !errors.As(err, &filterErr) || err.Error() != "filter gender invalid" {
+		t.Errorf("expected BadFilterError for gender, got %v", err)
+	}
+	_, err = client.FindUsers(SearchRequest{MinAge: 40, MaxAge: 20})
+	if !errors.As(err, &filterErr) || filterErr.Filter != "age" {
+		t.Errorf("expected BadFilterError for age, got %v", err)
+	}
+	_, err = client.FindUsers(SearchRequest{Ids: []int{-1}})
+	if !errors.As(err, &filterErr) || filterErr.Filter != "id" {
+		t.Errorf("expected BadFilterError for id, got %v", err)
+	}
+
+	_, err = client.FindUsers(SearchRequest{OrderField: "Id", Sort: []SortKey{{Field: "Age"}, {Field: "About", Desc: true}}})
+	orderErr := &BadOrderFieldError{}
+	if !errors.As(err, &orderErr) || orderErr.Field != "About desc" {
+		t.Errorf("expected BadOrderFieldError for About desc, got %v", err)
+	}
+	_, err = client.FindUsers(SearchRequest{Query: "boyd", QueryMode: QueryModeSubstring, Sort: []SortKey{{Field: OrderFieldRelevance}}})
+	if !errors.As(err, &orderErr) || orderErr.Field != OrderFieldRelevance {
+		t.Errorf("Relevance sort in substring mode must fail, got %v", err)
+	}
+}